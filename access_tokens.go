@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/pkg"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// maxTokenDays is the longest expiry POST /api/tokens accepts, the longest
+// the tokens page offers.
+const maxTokenDays = 365
+
+func (app *Application) ShowAccessTokensPage(c *gin.Context) {
+	user := app.currentUser(c)
+	tokens, err := app.Model.UsersORM.AccessTokens(c.Request.Context(), user.ID)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	otelgin.HTML(c, http.StatusOK, "tokens.html", gin.H{
+		"title":     "Access Tokens",
+		"Email":     user.Email,
+		"Tokens":    tokens,
+		"CSRFToken": app.pageCSRFToken(c),
+		"CSPNonce":  cspNonce(c),
+	})
+}
+
+func (app *Application) ListAccessTokens(c *gin.Context) {
+	tokens, err := app.Model.UsersORM.AccessTokens(c.Request.Context(), app.currentUser(c).ID)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, tokens)
+}
+
+// CreateAccessToken issues a token. The raw value is only in this response,
+// the server keeps nothing but its hash.
+func (app *Application) CreateAccessToken(c *gin.Context) {
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing token name")
+		return
+	}
+
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxTokenDays {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "expires_in_days must be between 0 and "+strconv.Itoa(maxTokenDays))
+		return
+	}
+
+	// zero days means the token doesn't expire
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	token, err := app.Model.UsersORM.CreateAccessToken(c.Request.Context(), app.currentUser(c).ID, req.Name, req.Scopes, expiresAt)
+	if err == pkg.ErrInvalidScope {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Scopes must be one or more of drive:read, drive:write, share:manage")
+		return
+	}
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusCreated, token)
+}
+
+func (app *Application) RevokeAccessToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "Token not found")
+		return
+	}
+
+	err = app.Model.UsersORM.RevokeAccessToken(c.Request.Context(), app.currentUser(c).ID, uint(id))
+	if err == pkg.ErrNoRecord {
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "Token not found")
+		return
+	}
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Token Revoked")
+}
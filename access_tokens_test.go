@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-drive/models"
+)
+
+// createToken creates a token through POST /api/tokens.
+func createToken(t *testing.T, r http.Handler, cookies []*http.Cookie, csrf, body string) (*models.NewAccessToken, int) {
+	t.Helper()
+	w := serve(r, newRequest(http.MethodPost, "/api/tokens", body, cookies, csrf))
+	if w.Code != http.StatusCreated {
+		return nil, w.Code
+	}
+	var resp struct {
+		Message models.NewAccessToken `json:"message"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return &resp.Message, w.Code
+}
+
+// withToken sends a request authenticated by a personal access token.
+func withToken(r http.Handler, method, path, body, token string) int {
+	req := newRequest(method, path, body, nil, "")
+	req.Header.Set("Authorization", "Bearer "+token)
+	return serve(r, req).Code
+}
+
+func TestCreateAccessTokenExpiry(t *testing.T) {
+	app, db := newTestApp(t)
+	r := app.InitRouter()
+	createTestUser(t, db, "user@example.com", "secret123")
+	cookies := passwordLogin(t, r, "user@example.com", "secret123")
+	csrf := pageCSRF(t, r, cookies)
+
+	tests := []struct {
+		days   int
+		status int
+	}{
+		{-1, http.StatusBadRequest},
+		{0, http.StatusCreated},
+		{1, http.StatusCreated},
+		{maxTokenDays, http.StatusCreated},
+		{maxTokenDays + 1, http.StatusBadRequest},
+		{100000, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body := `{"name":"t","scopes":["drive:read"],"expires_in_days":` + strconv.Itoa(tt.days) + `}`
+		token, status := createToken(t, r, cookies, csrf, body)
+		if status != tt.status {
+			t.Errorf("expires_in_days %d: status %d, want %d", tt.days, status, tt.status)
+			continue
+		}
+		if token == nil {
+			continue
+		}
+		if tt.days == 0 && token.ExpiresAt != nil {
+			t.Errorf("expires_in_days 0: expires at %v, want never", token.ExpiresAt)
+		}
+		if tt.days > 0 {
+			want := time.Now().AddDate(0, 0, tt.days)
+			if token.ExpiresAt == nil || token.ExpiresAt.Sub(want).Abs() > time.Minute {
+				t.Errorf("expires_in_days %d: expires at %v, want about %v", tt.days, token.ExpiresAt, want)
+			}
+		}
+	}
+}
+
+func TestAccessTokenAuth(t *testing.T) {
+	app, db := newTestApp(t)
+	r := app.InitRouter()
+	createTestUser(t, db, "user@example.com", "secret123")
+	cookies := passwordLogin(t, r, "user@example.com", "secret123")
+	csrf := pageCSRF(t, r, cookies)
+
+	read, _ := createToken(t, r, cookies, csrf, `{"name":"reader","scopes":["drive:read"]}`)
+	write, _ := createToken(t, r, cookies, csrf, `{"name":"writer","scopes":["drive:write"]}`)
+	if read == nil || write == nil {
+		t.Fatal("couldn't create the tokens")
+	}
+
+	t.Run("scopes", func(t *testing.T) {
+		if code := withToken(r, http.MethodGet, "/drive/", "", read.Token); code != http.StatusOK {
+			t.Errorf("drive:read listing: status %d", code)
+		}
+		for _, write := range []struct{ method, path, body string }{
+			{http.MethodPost, "/drive/create", `{"folder_name":"a"}`},
+			{http.MethodPut, "/drive/rename", `{"old_path":"a","new_path":"b"}`},
+			{http.MethodDelete, "/drive/delete", `{"path":"a"}`},
+		} {
+			if code := withToken(r, write.method, write.path, write.body, read.Token); code != http.StatusForbidden {
+				t.Errorf("drive:read on %s %s: status %d, want 403", write.method, write.path, code)
+			}
+		}
+		if code := withToken(r, http.MethodPost, "/drive/create", `{"folder_name":"a"}`, write.Token); code != http.StatusOK {
+			t.Errorf("drive:write creating a folder: status %d", code)
+		}
+		if code := withToken(r, http.MethodGet, "/drive/", "", write.Token); code != http.StatusForbidden {
+			t.Errorf("drive:write listing: status %d, want 403", code)
+		}
+		// session, 2FA and token endpoints are for the signed in user only
+		if code := withToken(r, http.MethodGet, "/api/tokens", "", read.Token); code != http.StatusForbidden {
+			t.Errorf("token listing tokens: status %d, want 403", code)
+		}
+	})
+
+	t.Run("last used", func(t *testing.T) {
+		var row models.PersonalAccessToken
+		if err := db.First(&row, read.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if row.LastUsedAt == nil || time.Since(*row.LastUsedAt) > time.Minute {
+			t.Fatalf("last_used_at %v after use, want now", row.LastUsedAt)
+		}
+
+		// only written once a minute
+		stale := time.Now().Add(-2 * time.Minute)
+		recent := time.Now().Add(-30 * time.Second)
+		for _, at := range []time.Time{stale, recent} {
+			if err := db.Model(&row).Update("last_used_at", at).Error; err != nil {
+				t.Fatal(err)
+			}
+			withToken(r, http.MethodGet, "/drive/", "", read.Token)
+			if err := db.First(&row, read.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			moved := row.LastUsedAt.Sub(at).Abs() > time.Second
+			if moved != (at == stale) {
+				t.Errorf("last_used_at %v before the request, %v after", at, row.LastUsedAt)
+			}
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		token, _ := createToken(t, r, cookies, csrf, `{"name":"short","scopes":["drive:read"],"expires_in_days":1}`)
+		if code := withToken(r, http.MethodGet, "/drive/", "", token.Token); code != http.StatusOK {
+			t.Fatalf("before expiry: status %d", code)
+		}
+		if err := db.Model(&models.PersonalAccessToken{}).Where("id = ?", token.ID).
+			Update("expires_at", time.Now().Add(-time.Second)).Error; err != nil {
+			t.Fatal(err)
+		}
+		if code := withToken(r, http.MethodGet, "/drive/", "", token.Token); code != http.StatusUnauthorized {
+			t.Errorf("expired token: status %d, want 401", code)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		w := serve(r, newRequest(http.MethodDelete, "/api/tokens/"+strconv.FormatUint(uint64(read.ID), 10), "", cookies, csrf))
+		if w.Code != http.StatusOK {
+			t.Fatalf("revoke: status %d: %s", w.Code, w.Body)
+		}
+		if code := withToken(r, http.MethodGet, "/drive/", "", read.Token); code != http.StatusUnauthorized {
+			t.Errorf("revoked token: status %d, want 401", code)
+		}
+		// the other one still works
+		if code := withToken(r, http.MethodPost, "/drive/create", `{"folder_name":"b"}`, write.Token); code != http.StatusOK {
+			t.Errorf("the other token after revoking one: status %d", code)
+		}
+	})
+
+	if code := withToken(r, http.MethodGet, "/drive/", "", models.AccessTokenPrefix+"unknown"); code != http.StatusUnauthorized {
+		t.Errorf("unknown token: status %d, want 401", code)
+	}
+}
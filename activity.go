@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/models"
+)
+
+var (
+	errBadDate   = errors.New("dates must be YYYY-MM-DD or RFC 3339")
+	errBadAction = errors.New("unknown action")
+)
+
+// activityFilter reads the from, to, action and path query parameters. A
+// plain date in to includes that whole day.
+func activityFilter(c *gin.Context) (models.ActivityFilter, error) {
+	filter := models.ActivityFilter{
+		Action: models.Action(c.Query("action")),
+		Path:   c.Query("path"),
+	}
+	if filter.Action != "" && !filter.Action.Valid() {
+		return filter, errBadAction
+	}
+
+	var err error
+	if filter.From, err = parseQueryTime(c.Query("from"), false); err != nil {
+		return filter, err
+	}
+	if filter.To, err = parseQueryTime(c.Query("to"), true); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func parseQueryTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, errBadDate
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// ListActivity returns the signed in user's own activity.
+func (app *Application) ListActivity(c *gin.Context) {
+	filter, err := activityFilter(c)
+	if err != nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter.UserID = app.currentUser(c).ID
+	app.listActivity(c, filter)
+}
+
+func (app *Application) ExportActivity(c *gin.Context) {
+	filter, err := activityFilter(c)
+	if err != nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter.UserID = app.currentUser(c).ID
+	app.exportActivity(c, filter)
+}
+
+// AdminListActivity is ListActivity across all users, or the one given by
+// ?user_id=.
+func (app *Application) AdminListActivity(c *gin.Context) {
+	filter, ok := app.adminActivityFilter(c)
+	if !ok {
+		return
+	}
+	app.listActivity(c, filter)
+}
+
+func (app *Application) AdminExportActivity(c *gin.Context) {
+	filter, ok := app.adminActivityFilter(c)
+	if !ok {
+		return
+	}
+	app.exportActivity(c, filter)
+}
+
+func (app *Application) adminActivityFilter(c *gin.Context) (models.ActivityFilter, bool) {
+	filter, err := activityFilter(c)
+	if err != nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		return filter, false
+	}
+
+	if v := c.Query("user_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Invalid user_id")
+			return filter, false
+		}
+		filter.UserID = uint(id)
+	}
+	return filter, true
+}
+
+func (app *Application) listActivity(c *gin.Context, filter models.ActivityFilter) {
+	page, perPage := pagination(c)
+	logs, total, err := app.Model.UsersORM.ActivityLogs(c.Request.Context(), filter, page, perPage)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{
+		"activity": logs,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+// exportActivity streams every matching row as ?format=csv (default) or json.
+func (app *Application) exportActivity(c *gin.Context, filter models.ActivityFilter) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	filename := "activity-" + time.Now().Format("20060102-150405") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("Cache-Control", "no-store")
+
+	var err error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		err = app.writeActivityCSV(c, filter)
+	} else {
+		c.Header("Content-Type", "application/json")
+		err = app.writeActivityJSON(c, filter)
+	}
+
+	// the status line is gone by now, all that's left is to log it
+	if err != nil {
+		app.log(c).Error("Activity export failed: ", err)
+	}
+}
+
+func (app *Application) writeActivityCSV(c *gin.Context, filter models.ActivityFilter) error {
+	w := csv.NewWriter(c.Writer)
+	header := []string{"id", "user_id", "actor_id", "action", "activity", "target_path", "old_path", "size",
+		"outcome", "details", "ip_addr", "user_agent", "request_id", "created_at", "prev_hash", "user_prev_hash", "hash"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	err := app.Model.UsersORM.ExportActivity(c.Request.Context(), filter, func(logs []models.UserActivityLog) error {
+		for _, l := range logs {
+			actor, created := "", ""
+			if l.ActorID != nil {
+				actor = strconv.FormatUint(uint64(*l.ActorID), 10)
+			}
+			if l.CreatedAt != nil {
+				created = l.CreatedAt.Format(time.RFC3339)
+			}
+
+			if err := w.Write([]string{
+				strconv.FormatUint(uint64(l.ID), 10),
+				strconv.FormatUint(uint64(l.UserID), 10),
+				actor,
+				string(l.Action),
+				csvSafe(l.Activity),
+				csvSafe(l.TargetPath),
+				csvSafe(l.OldPath),
+				strconv.FormatInt(l.Size, 10),
+				l.Outcome,
+				string(l.Details),
+				l.IpAddr,
+				csvSafe(l.UserAgent),
+				l.RequestID,
+				created,
+				l.PrevHash,
+				l.UserPrevHash,
+				l.Hash,
+			}); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+	w.Flush()
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+func (app *Application) writeActivityJSON(c *gin.Context, filter models.ActivityFilter) error {
+	enc := json.NewEncoder(c.Writer)
+	if _, err := c.Writer.WriteString("["); err != nil {
+		return err
+	}
+
+	first := true
+	err := app.Model.UsersORM.ExportActivity(c.Request.Context(), filter, func(logs []models.UserActivityLog) error {
+		for _, l := range logs {
+			if !first {
+				if _, err := c.Writer.WriteString(","); err != nil {
+					return err
+				}
+			}
+			first = false
+
+			if err := enc.Encode(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Writer.WriteString("]\n")
+	return err
+}
+
+// csvSafe stops spreadsheet apps from running a file name like "=cmd()"
+// from the export as a formula.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
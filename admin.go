@@ -0,0 +1,212 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/pkg"
+)
+
+// adminTargetID reads the :id of the user an admin endpoint acts on.
+func (app *Application) adminTargetID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "User not found")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// adminError answers the errors every admin user action can return.
+func (app *Application) adminError(c *gin.Context, err error) {
+	switch err {
+	case pkg.ErrUserNotFound:
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "User not found")
+	case pkg.ErrInvalidUserFound:
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Admins can't do this to their own account")
+	default:
+		app.ServerError(c, err)
+	}
+}
+
+// ListUsers pages through users, optionally filtered by ?q= on the email.
+func (app *Application) ListUsers(c *gin.Context) {
+	page, perPage := pagination(c)
+	users, total, err := app.Model.UsersORM.ListUsers(c.Request.Context(), c.Query("q"), page, perPage)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	for i := range users {
+		users[i].UsageBytes, err = tracedDirUsage(c.Request.Context(), app.userBaseDir(users[i].ID))
+		if err != nil {
+			app.ServerError(c, err)
+			return
+		}
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{
+		"users":    users,
+		"total":    total,
+		"page":     page,
+		"per_page": perPage,
+	})
+}
+
+func (app *Application) GetUser(c *gin.Context) {
+	id, ok := app.adminTargetID(c)
+	if !ok {
+		return
+	}
+
+	user, err := app.Model.UsersORM.AdminUserByID(c.Request.Context(), id)
+	if err != nil {
+		app.adminError(c, err)
+		return
+	}
+
+	user.UsageBytes, err = tracedDirUsage(c.Request.Context(), app.userBaseDir(user.ID))
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, user)
+}
+
+// SetUserActive switches an account on or off.
+func (app *Application) SetUserActive(c *gin.Context) {
+	id, ok := app.adminTargetID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Active *bool `json:"active"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Active == nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing active")
+		return
+	}
+
+	if err := app.Model.UsersORM.SetUserActive(c.Request.Context(), app.currentUser(c).ID, id, *req.Active); err != nil {
+		app.adminError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Account Updated")
+}
+
+// ForcePasswordReset voids the user's password and emails them a reset link.
+func (app *Application) ForcePasswordReset(c *gin.Context) {
+	id, ok := app.adminTargetID(c)
+	if !ok {
+		return
+	}
+
+	reset, err := app.Model.UsersORM.ForcePasswordReset(c.Request.Context(), app.currentUser(c).ID, id)
+	if err != nil {
+		app.adminError(c, err)
+		return
+	}
+
+	app.sendMail(reset.Email, "Reset your Drive password", "password_reset", map[string]any{
+		"Link":      app.BaseURL + "/reset-password/" + reset.Token,
+		"ExpiresAt": reset.ExpiresAt,
+	})
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Password reset link sent")
+}
+
+func (app *Application) RevokeUserSessions(c *gin.Context) {
+	id, ok := app.adminTargetID(c)
+	if !ok {
+		return
+	}
+
+	if err := app.Model.UsersORM.AdminRevokeSessions(c.Request.Context(), app.currentUser(c).ID, id); err != nil {
+		app.adminError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Sessions Revoked")
+}
+
+func (app *Application) SetUserQuota(c *gin.Context) {
+	id, ok := app.adminTargetID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		QuotaBytes *int64 `json:"quota_bytes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.QuotaBytes == nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing quota_bytes")
+		return
+	}
+
+	err := app.Model.UsersORM.SetUserQuota(c.Request.Context(), app.currentUser(c).ID, id, *req.QuotaBytes)
+	if err == pkg.ErrInvalidQuota {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		app.adminError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Quota Updated")
+}
+
+// DeleteUser removes the account, then its drive directory.
+func (app *Application) DeleteUser(c *gin.Context) {
+	id, ok := app.adminTargetID(c)
+	if !ok {
+		return
+	}
+
+	if err := app.Model.UsersORM.DeleteUser(c.Request.Context(), app.currentUser(c).ID, id); err != nil {
+		app.adminError(c, err)
+		return
+	}
+
+	dir := app.userBaseDir(id)
+	if err := traceFS(c.Request.Context(), "remove", dir, func() error { return os.RemoveAll(dir) }); err != nil {
+		app.log(c).Error("Error removing drive of deleted user ", id, ": ", err)
+		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "User deleted but the drive directory could not be removed")
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "User Deleted")
+}
+
+// SetUserRole assigns user, admin or auditor to another account.
+func (app *Application) SetUserRole(c *gin.Context) {
+	id, ok := app.adminTargetID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing role")
+		return
+	}
+
+	err := app.Model.UsersORM.SetUserRole(c.Request.Context(), app.currentUser(c).ID, id, req.Role)
+	if err == pkg.ErrInvalidRole {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Role must be user, admin or auditor")
+		return
+	}
+	if err != nil {
+		app.adminError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Role Updated")
+}
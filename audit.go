@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"github.com/iamgak/go-drive/pkg/audit"
+)
+
+type auditConfig struct {
+	// Key signs checkpoints; without it none are written.
+	Key            ed25519.PrivateKey
+	CheckpointFile string
+	Interval       time.Duration
+}
+
+func loadAuditConfig() (auditConfig, error) {
+	cfg := auditConfig{
+		CheckpointFile: pkg.EnvString("AUDIT_CHECKPOINT_FILE", "audit-checkpoints.jsonl"),
+		Interval:       pkg.EnvDuration("AUDIT_CHECKPOINT_INTERVAL", time.Hour),
+	}
+
+	if v := os.Getenv("AUDIT_SIGNING_KEY"); v != "" {
+		key, err := audit.ParseKey(v)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Key = key
+	}
+	return cfg, nil
+}
+
+// runAuditCheckpoints signs the head of the activity chain every interval
+// and appends it to the checkpoint file, until ctx is done.
+func (app *Application) runAuditCheckpoints(ctx context.Context) {
+	last, err := audit.Last(app.Audit.CheckpointFile)
+	if err != nil {
+		app.Logger.Error("Error reading audit checkpoints: ", err)
+		return
+	}
+
+	ticker := time.NewTicker(app.Audit.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cp, err := app.writeAuditCheckpoint(ctx, last)
+		if err != nil {
+			app.Logger.Error("Error writing audit checkpoint: ", err)
+			continue
+		}
+		if cp != nil {
+			last = cp
+		}
+	}
+}
+
+// writeAuditCheckpoint appends a checkpoint of the current head, unless
+// nothing was logged since last.
+func (app *Application) writeAuditCheckpoint(ctx context.Context, last *audit.Checkpoint) (*audit.Checkpoint, error) {
+	head, err := app.Model.UsersORM.ActivityHead(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if head.EntryID == 0 || (last != nil && last.EntryID == head.EntryID) {
+		return nil, nil
+	}
+
+	cp := audit.Checkpoint{
+		EntryID:   head.EntryID,
+		Hash:      head.Hash,
+		Entries:   head.Entries,
+		CreatedAt: time.Now(),
+	}
+	cp.Sign(app.Audit.Key)
+	if err := audit.Append(app.Audit.CheckpointFile, cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// verifyAudit is the verify-audit command. It checks the activity chain and
+// then every checkpoint against it, prints what it found, and returns the
+// process exit code: 0 intact, 1 tampered with, 2 couldn't check.
+func (app *Application) verifyAudit(ctx context.Context) int {
+	checked, broken, err := app.Model.UsersORM.VerifyActivityChain(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "verify-audit:", err)
+		return 2
+	}
+	if broken != nil {
+		fmt.Printf("BROKEN at entry %d (user %d): %s\n", broken.EntryID, broken.UserID, broken.Reason)
+		fmt.Printf("%d entries checked\n", checked)
+		return 1
+	}
+	fmt.Printf("chain intact, %d entries checked\n", checked)
+
+	checkpoints, err := audit.ReadFile(app.Audit.CheckpointFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "verify-audit:", err)
+		return 2
+	}
+	if app.Audit.Key == nil && len(checkpoints) > 0 {
+		fmt.Println("AUDIT_SIGNING_KEY not set, checkpoint signatures not checked")
+	}
+
+	for _, cp := range checkpoints {
+		if problem := app.checkCheckpoint(ctx, cp); problem != "" {
+			fmt.Printf("BROKEN at checkpoint of %s (entry %d): %s\n", cp.CreatedAt.Format(time.RFC3339), cp.EntryID, problem)
+			return 1
+		}
+	}
+	fmt.Printf("%d checkpoints match\n", len(checkpoints))
+	return 0
+}
+
+// checkCheckpoint returns what's wrong with cp, or "" if it matches the log.
+func (app *Application) checkCheckpoint(ctx context.Context, cp audit.Checkpoint) string {
+	if app.Audit.Key != nil {
+		if err := cp.Verify(app.Audit.Key.Public().(ed25519.PublicKey)); err != nil {
+			return "signature does not verify, the checkpoint file was edited"
+		}
+	}
+
+	head, err := app.Model.UsersORM.ActivityHead(ctx, cp.EntryID)
+	if errors.Is(err, pkg.ErrNoRecord) {
+		return "entry is missing from the log"
+	}
+	if err != nil {
+		return err.Error()
+	}
+
+	if head.Hash != cp.Hash {
+		return "entry hash differs, the chain was rewritten"
+	}
+	if head.Entries != cp.Entries {
+		return fmt.Sprintf("log has %d entries up to here, checkpoint recorded %d", head.Entries, cp.Entries)
+	}
+	return ""
+}
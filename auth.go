@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg"
+)
+
+const (
+	accessCookie  = "ldata"
+	refreshCookie = "rdata"
+)
+
+// setAuthCookies hands a fresh token pair to the browser. Both cookies are
+// HttpOnly; the refresh token outlives the access token so the page can
+// silently call /refresh once the latter expires.
+func (app *Application) setAuthCookies(w http.ResponseWriter, tokens *models.AuthTokens) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    tokens.AccessToken,
+		HttpOnly: true,
+		Secure:   app.TLSEnabled, // Only for HTTPS
+		Path:     "/",
+		MaxAge:   int(time.Until(tokens.AccessExpiresAt).Seconds()),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    tokens.RefreshToken,
+		HttpOnly: true,
+		Secure:   app.TLSEnabled,
+		Path:     "/",
+		MaxAge:   int(time.Until(tokens.RefreshExpiresAt).Seconds()),
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func (app *Application) clearAuthCookies(w http.ResponseWriter) {
+	for _, name := range []string{accessCookie, refreshCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			HttpOnly: true,
+			Secure:   app.TLSEnabled,
+			Path:     "/",
+			MaxAge:   -1,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// refreshTokenFromRequest takes the refresh token from the rdata cookie, or
+// from a {"refresh_token": "..."} body for clients that don't keep cookies.
+func refreshTokenFromRequest(c *gin.Context) (token string, fromBody bool) {
+	if cookie, err := c.Request.Cookie(refreshCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, false
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.ShouldBindJSON(&req); err == nil && req.RefreshToken != "" {
+		return req.RefreshToken, true
+	}
+
+	return "", false
+}
+
+func (app *Application) RefreshSession(c *gin.Context) {
+	refreshToken, fromBody := refreshTokenFromRequest(c)
+	if refreshToken == "" {
+		app.ErrorJSONResponse(c.Writer, http.StatusUnauthorized, "Missing refresh token")
+		return
+	}
+
+	// a token in the body was sent on purpose, only the cookie can be forged
+	if !fromBody {
+		safe, err := app.refreshCSRFSafe(c, refreshToken)
+		if err != nil {
+			app.ServerError(c, err)
+			return
+		}
+		if !safe {
+			app.csrfRejected(c)
+			return
+		}
+	}
+
+	tokens, err := app.Model.UsersORM.RefreshSession(c.Request.Context(), refreshToken)
+	if err != nil {
+		app.log(c).Warn("Refresh failed: ", err)
+		switch {
+		case errors.Is(err, pkg.ErrInvalidToken), errors.Is(err, pkg.ErrSessionRevoked),
+			errors.Is(err, pkg.ErrRefreshTokenReused), errors.Is(err, pkg.ErrAccountInActive):
+			app.clearAuthCookies(c.Writer)
+			app.ErrorJSONResponse(c.Writer, http.StatusUnauthorized, err.Error())
+		default:
+			app.ServerError(c, err)
+		}
+		return
+	}
+
+	app.setAuthCookies(c.Writer, tokens)
+	if fromBody {
+		app.sendJSONResponse(c.Writer, http.StatusOK, tokens)
+		return
+	}
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Session Refreshed")
+}
+
+// UserLogout revokes the whole session family, found via the refresh token
+// or, failing that, via a still valid access token.
+func (app *Application) UserLogout(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var userID uint
+	var err error
+	if refreshToken, fromBody := refreshTokenFromRequest(c); refreshToken != "" {
+		if !fromBody {
+			safe, err := app.refreshCSRFSafe(c, refreshToken)
+			if err != nil {
+				app.ServerError(c, err)
+				return
+			}
+			if !safe {
+				app.csrfRejected(c)
+				return
+			}
+		}
+		userID, err = app.Model.UsersORM.LogoutSession(ctx, refreshToken)
+	} else if cookie, cerr := c.Request.Cookie(accessCookie); cerr == nil && cookie.Value != "" {
+		var claims *models.MyCustomClaims
+		if claims, err = app.parseAccessToken(cookie.Value); err == nil {
+			if !csrfSafe(c, claims.SessionID) {
+				app.csrfRejected(c)
+				return
+			}
+			userID = claims.UserID
+			err = app.Model.UsersORM.RevokeSessionFamily(ctx, claims.SessionID)
+		}
+	}
+
+	app.clearAuthCookies(c.Writer)
+	if err != nil && !errors.Is(err, pkg.ErrInvalidToken) {
+		app.log(c).Error("Logout failed: ", err)
+	}
+
+	if userID != 0 {
+		app.recordEvent(c, models.Event{Action: models.ActionLogout, UserID: userID})
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Logged Out")
+}
@@ -1,19 +1,18 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"io"
-	"log"
 	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
-	"text/template"
 
 	"github.com/gin-gonic/gin"
 	"github.com/iamgak/go-drive/models"
 	"github.com/iamgak/go-drive/pkg"
+	"github.com/iamgak/go-drive/pkg/safepath"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 type FileEntry struct {
@@ -27,18 +26,23 @@ type DriveTemplateData struct {
 	ParentPath  string
 	ShowBack    bool
 	Entries     []FileEntry
+	CSRFToken   string
+	CSPNonce    string
 }
 
 func (app *Application) ShowLoginPage(c *gin.Context) {
 
-	c.HTML(http.StatusOK, "login.html", gin.H{
-		"title": "Login",
+	otelgin.HTML(c, http.StatusOK, "login.html", gin.H{
+		"title":     "Login",
+		"Providers": app.OIDCProviders,
+		"CSPNonce":  cspNonce(c),
 	})
 }
 
 func (app *Application) ShowRegisterPage(c *gin.Context) {
-	c.HTML(http.StatusOK, "register.html", gin.H{
-		"title": "Register",
+	otelgin.HTML(c, http.StatusOK, "register.html", gin.H{
+		"title":    "Register",
+		"CSPNonce": cspNonce(c),
 	})
 }
 
@@ -46,12 +50,17 @@ func (app *Application) UserActivateAccount(c *gin.Context) {
 	token := c.Param("token")
 	err := app.Model.UsersORM.ActivateAccount(c, token)
 	if err != nil {
-		app.Logger.Error(err.Error())
+		app.log(c).Error(err.Error())
 		if err == pkg.ErrNoRecord {
 			app.ErrorJSONResponse(c.Writer, http.StatusNotFound, err.Error())
 			return
 		}
 
+		if err == pkg.ErrInvalidToken {
+			app.ErrorJSONResponse(c.Writer, http.StatusGone, "Activation link expired, request a new one")
+			return
+		}
+
 		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
 		return
 	}
@@ -59,10 +68,36 @@ func (app *Application) UserActivateAccount(c *gin.Context) {
 	app.sendJSONResponse(c.Writer, http.StatusOK, "Account Activated Successfully")
 }
 
+// ResendActivation mails a fresh activation link. The reply is the same
+// whether or not the email belongs to an inactive account, and repeated
+// requests within the resend interval are silently dropped.
+func (app *Application) ResendActivation(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing email")
+		return
+	}
+
+	activation, err := app.Model.UsersORM.ResendActivation(c.Request.Context(), req.Email)
+	switch err {
+	case nil:
+		app.sendActivationMail(req.Email, activation.Token, activation.ExpiresAt)
+	case pkg.ErrNoRecord, pkg.ErrTooManyRequests:
+		app.log(c).Info("Activation resend skipped: ", err)
+	default:
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "If the account exists and is not active yet, a new activation email has been sent")
+}
+
 func (app *Application) UserLogin(c *gin.Context) {
 	var creds *models.UserStruct
 	if err := c.ShouldBindJSON(&creds); err != nil {
-		app.Logger.Error("Loading Input Data Err :", err.Error())
+		app.log(c).Error("Loading Input Data Err :", err.Error())
 		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
 		return
 	}
@@ -73,16 +108,21 @@ func (app *Application) UserLogin(c *gin.Context) {
 		return
 	}
 
-	token, err := app.Model.UsersORM.LoginUser(c.Request.Context(), creds)
+	if app.loginIPBlocked(c) {
+		return
+	}
+
+	result, err := app.Model.UsersORM.LoginUser(c.Request.Context(), creds)
 	if err != nil {
-		app.Logger.Error(err.Error())
+		app.log(c).Error(err.Error())
 		if err == pkg.ErrAccountInActive {
+			app.Metrics.login("password", false)
 			app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
 			return
 		}
 
-		if err == pkg.ErrInvalidCredentials {
-			app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		if err == pkg.ErrInvalidCredentials || errors.Is(err, pkg.ErrAccountLocked) || errors.Is(err, pkg.ErrTooManyRequests) {
+			app.loginFailed(c, err)
 			return
 		}
 
@@ -90,22 +130,25 @@ func (app *Application) UserLogin(c *gin.Context) {
 		return
 	}
 
-	http.SetCookie(c.Writer, &http.Cookie{
-		Name:     "ldata",
-		Value:    token,
-		HttpOnly: true,
-		// Secure:   true, // Only for HTTPS
-		Path:     "/",
-		MaxAge:   4 * 3600, // 4 hour
-		SameSite: http.SameSiteStrictMode,
-	})
+	// with 2FA on no cookie is set until /login/2fa accepts a code, which
+	// is where the login is counted
+	if result.MFAToken != "" {
+		app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"mfa_token":           result.MFAToken,
+		})
+		return
+	}
+
+	app.Metrics.login("password", true)
+	app.setAuthCookies(c.Writer, result.Tokens)
 	app.sendJSONResponse(c.Writer, http.StatusOK, "Login Successfull")
 }
 
 func (app *Application) UserRegister(c *gin.Context) {
 	var creds *models.UserStruct
 	if err := c.ShouldBindJSON(&creds); err != nil {
-		app.Logger.Error("Loading Input Data Err :", err.Error())
+		app.log(c).Error("Loading Input Data Err :", err.Error())
 		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
 		return
 	}
@@ -116,12 +159,15 @@ func (app *Application) UserRegister(c *gin.Context) {
 		return
 	}
 
-	if err := app.Model.UsersORM.RegisterUser(c.Request.Context(), creds.Email, creds.Password, c.ClientIP()); err != nil {
-		app.Logger.Error(err.Error())
+	activation, err := app.Model.UsersORM.RegisterUser(c.Request.Context(), creds.Email, creds.Password)
+	if err != nil {
+		app.log(c).Error(err.Error())
 		app.sendJSONResponse(c.Writer, http.StatusBadRequest, "Internal Server Error")
 		return
 	}
 
+	app.sendActivationMail(creds.Email, activation.Token, activation.ExpiresAt)
+
 	app.sendJSONResponse(c.Writer, http.StatusCreated, "Registration Successfully")
 }
 
@@ -137,36 +183,36 @@ func (app *Application) CreateFolder(c *gin.Context) {
 		return
 	}
 
-	// Combine base directory + save path + folder name
-	relPath := filepath.Join(req.SavePath, req.FolderName)
-	fullPath, err := filepath.Abs(filepath.Join(app.BaseDir, relPath))
+	folderName, err := safepath.SanitizeFilename(req.FolderName)
 	if err != nil {
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to resolve path")
+		app.pathError(c, err)
 		return
 	}
 
-	baseAbs, err := filepath.Abs(app.BaseDir)
+	root, err := app.userRoot(c)
 	if err != nil {
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to resolve base path")
+		app.ServerError(c, err)
 		return
 	}
 
-	if !strings.HasPrefix(fullPath, baseAbs) {
-		app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access denied")
+	// Combine base directory + save path + folder name
+	relPath := filepath.Join(req.SavePath, folderName)
+	fullPath, err := root.Resolve(relPath)
+	if err != nil {
+		app.pathError(c, err)
 		return
 	}
 
-	err = os.MkdirAll(fullPath, 0755)
+	err = traceFS(c.Request.Context(), "mkdir", fullPath, func() error {
+		return os.MkdirAll(fullPath, 0755)
+	})
 	if err != nil {
 		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Could not create folder")
 		return
 	}
 
-	activity := models.UserActivityLog{UserID: app.UserID, Activity: fmt.Sprintf("Folder Created: %s ", relPath), IpAddr: c.ClientIP()}
-	err = app.Model.UsersORM.UserActivityLog(&activity)
-	if err != nil {
-		log.Println("Error creating folder activity ", err)
-	}
+	rel, _ := root.Rel(fullPath)
+	app.recordEvent(c, models.Event{Action: models.ActionFolderCreated, UserID: app.currentUser(c).ID, TargetPath: rel})
 	app.sendJSONResponse(c.Writer, http.StatusOK, "Folder created")
 }
 
@@ -180,8 +226,19 @@ func (app *Application) DeleteFileOrFolder(c *gin.Context) {
 		return
 	}
 
-	target := filepath.Clean(filepath.Join(app.BaseDir, req.Path))
-	if !strings.HasPrefix(target, app.BaseDir) {
+	root, err := app.userRoot(c)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	target, err := root.Resolve(req.Path)
+	if err != nil {
+		app.pathError(c, err)
+		return
+	}
+
+	if target == root.Path() {
 		app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access denied")
 		return
 	}
@@ -196,21 +253,25 @@ func (app *Application) DeleteFileOrFolder(c *gin.Context) {
 		return
 	}
 
-	if info.IsDir() {
-		err = os.RemoveAll(target)
-	} else {
-		err = os.Remove(target)
-	}
+	err = traceFS(c.Request.Context(), "remove", target, func() error {
+		if info.IsDir() {
+			return os.RemoveAll(target)
+		}
+		return os.Remove(target)
+	})
 	if err != nil {
 		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to delete")
 		return
 	}
 
-	activity := models.UserActivityLog{UserID: app.UserID, Activity: fmt.Sprintf("File Deleted: %s ", target), IpAddr: c.ClientIP()}
-	err = app.Model.UsersORM.UserActivityLog(&activity)
-	if err != nil {
-		log.Println("Error deleting file activity ", err)
-	}
+	rel, _ := root.Rel(target)
+	app.recordEvent(c, models.Event{
+		Action:     models.ActionFileDeleted,
+		UserID:     app.currentUser(c).ID,
+		TargetPath: rel,
+		Size:       info.Size(),
+		Details:    map[string]any{"dir": info.IsDir()},
+	})
 	app.sendJSONResponse(c.Writer, http.StatusOK, "Folder deleted")
 }
 
@@ -220,40 +281,70 @@ func (app *Application) RenameFolder(c *gin.Context) {
 		NewPath string `json:"new_path"`
 	}
 	var req Req
-	if err := c.ShouldBindJSON(&req); err != nil || req.OldPath == "" {
+	if err := c.ShouldBindJSON(&req); err != nil || req.OldPath == "" || req.NewPath == "" {
 		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Invalid input")
 		return
 	}
 
-	oldFull := filepath.Clean(filepath.Join(app.BaseDir, req.OldPath))
-	newFull := filepath.Clean(filepath.Join(app.BaseDir, req.NewPath))
+	root, err := app.userRoot(c)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
 
-	if !strings.HasPrefix(oldFull, app.BaseDir) || !strings.HasPrefix(newFull, app.BaseDir) {
-		app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access denied")
+	oldFull, err := root.Resolve(req.OldPath)
+	if err != nil {
+		app.pathError(c, err)
 		return
 	}
 
-	err := os.Rename(oldFull, newFull)
+	newFull, err := root.Resolve(req.NewPath)
 	if err != nil {
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Could not rename folder")
+		app.pathError(c, err)
 		return
 	}
 
-	activity := models.UserActivityLog{UserID: app.UserID, Activity: fmt.Sprintf("File Renamed: %s to %s ", req.OldPath, req.NewPath), IpAddr: c.ClientIP()}
-	err = app.Model.UsersORM.UserActivityLog(&activity)
+	if isTempUpload(filepath.Base(newFull)) {
+		app.pathError(c, pkg.ErrInvalidFilename)
+		return
+	}
+
+	if oldFull == root.Path() || newFull == root.Path() {
+		app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access denied")
+		return
+	}
+
+	err = traceFS(c.Request.Context(), "rename", oldFull, func() error {
+		return os.Rename(oldFull, newFull)
+	})
 	if err != nil {
-		log.Println("Error renaming file activity ", err)
+		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Could not rename folder")
+		return
 	}
+
+	oldRel, _ := root.Rel(oldFull)
+	newRel, _ := root.Rel(newFull)
+	app.recordEvent(c, models.Event{Action: models.ActionFileRenamed, UserID: app.currentUser(c).ID, OldPath: oldRel, TargetPath: newRel})
 	app.sendJSONResponse(c.Writer, http.StatusOK, "Folder Renamed")
 }
 
 func (app *Application) UploadFile(c *gin.Context) {
 
-	uploadDir := filepath.Join(app.BaseDir, c.PostForm("save_path")) // e.g., /drive/6/new
+	root, err := app.userRoot(c)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	uploadDir, err := root.Resolve(c.PostForm("save_path")) // e.g., /drive/6/new
+	if err != nil {
+		app.pathError(c, err)
+		return
+	}
 
 	// Ensure directory exists
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to create upload directory: "+err.Error())
+		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to create upload directory")
 		return
 	}
 
@@ -276,6 +367,14 @@ func (app *Application) UploadFile(c *gin.Context) {
 		return
 	}
 
+	if err := app.checkQuota(c, header.Size); err == pkg.ErrQuotaExceeded {
+		app.ErrorJSONResponse(c.Writer, http.StatusInsufficientStorage, "Storage quota exceeded")
+		return
+	} else if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
 	// Read first 512 bytes to detect MIME
 	buffer := make([]byte, 512)
 	_, err = file.Read(buffer)
@@ -299,61 +398,70 @@ func (app *Application) UploadFile(c *gin.Context) {
 		return
 	}
 
-	// Create destination file
-	dstPath := filepath.Join(uploadDir, header.Filename)
-
-	out, err := os.Create(dstPath)
+	fileName, err := safepath.SanitizeFilename(header.Filename)
+	if err == nil && isTempUpload(fileName) {
+		err = pkg.ErrInvalidFilename
+	}
 	if err != nil {
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to create file: "+err.Error())
+		app.pathError(c, err)
 		return
 	}
-	defer out.Close()
 
-	// Save the file
-	_, err = io.Copy(out, file)
+	// Create destination file, re-resolved in case MkdirAll went through a
+	// directory swapped for a symlink in the meantime
+	dstPath, err := root.Resolve(filepath.Join(c.PostForm("save_path"), fileName))
 	if err != nil {
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to save file")
+		app.pathError(c, err)
 		return
 	}
 
-	activity := models.UserActivityLog{UserID: app.UserID, Activity: "File Uploaded: " + header.Filename}
-	err = app.Model.UsersORM.UserActivityLog(&activity)
+	// Save the file under a temp name first, renamed into place once complete
+	var size int64
+	err = traceFS(c.Request.Context(), "write", dstPath, func() (err error) {
+		size, err = writeFileAtomic(dstPath, file)
+		return err
+	})
 	if err != nil {
-		log.Println("Error saving activity ", err)
+		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to save file")
+		return
 	}
+
+	app.Metrics.transfer("upload", size)
+	rel, _ := root.Rel(dstPath)
+	app.recordEvent(c, models.Event{
+		Action:     models.ActionFileUploaded,
+		UserID:     app.currentUser(c).ID,
+		TargetPath: rel,
+		Size:       size,
+		Details:    map[string]any{"content_type": contentType},
+	})
 	app.sendJSONResponse(c.Writer, http.StatusOK, "File uploaded successfully")
 }
 
 func (app *Application) DriveListing(c *gin.Context) {
-	path := strings.TrimPrefix(c.Param("path"), "/")
-	fullPath := filepath.Clean(filepath.Join(app.BaseDir, path))
-
-	baseAbs, err := filepath.Abs(app.BaseDir)
+	root, err := app.userRoot(c) // initial folder for user where he roam
 	if err != nil {
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to resolve base path")
+		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Base directory not found and could not be created")
 		return
 	}
 
-	fullAbs, err := filepath.Abs(fullPath)
+	fullAbs, err := root.Resolve(c.Param("path"))
 	if err != nil {
-		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Failed to resolve path")
+		app.pathError(c, err)
 		return
 	}
 
-	if _, err := os.Stat(app.BaseDir); os.IsNotExist(err) {
-		err := os.MkdirAll(app.BaseDir, 0755) // initial folder for user where he roam
-		if err != nil {
-			app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Base directory not found and could not be created")
-			return
-		}
-	}
-
-	if !strings.HasPrefix(fullAbs, baseAbs) {
-		app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access denied")
+	path, err := root.Rel(fullAbs)
+	if err != nil {
+		app.pathError(c, err)
 		return
 	}
 
-	info, err := os.Stat(fullAbs)
+	var info os.FileInfo
+	err = traceFS(c.Request.Context(), "stat", fullAbs, func() (err error) {
+		info, err = os.Stat(fullAbs)
+		return err
+	})
 	if os.IsNotExist(err) {
 		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "File or directory not found")
 		return
@@ -364,7 +472,11 @@ func (app *Application) DriveListing(c *gin.Context) {
 	}
 
 	if info.IsDir() {
-		files, err := os.ReadDir(fullAbs)
+		var files []os.DirEntry
+		err := traceFS(c.Request.Context(), "read_dir", fullAbs, func() (err error) {
+			files, err = os.ReadDir(fullAbs)
+			return err
+		})
 		if err != nil {
 			app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Unable to read directory")
 			return
@@ -372,9 +484,13 @@ func (app *Application) DriveListing(c *gin.Context) {
 
 		var entries []FileEntry
 		for _, f := range files {
+			if isTempUpload(f.Name()) {
+				continue
+			}
+
 			entry := FileEntry{
 				Name: f.Name(),
-				Path: filepath.Join(path, f.Name()),
+				Path: filepath.ToSlash(filepath.Join(path, f.Name())),
 				Icon: "📁",
 			}
 			if !f.IsDir() {
@@ -383,30 +499,40 @@ func (app *Application) DriveListing(c *gin.Context) {
 			entries = append(entries, entry)
 		}
 
-		data := DriveTemplateData{
+		otelgin.HTML(c, http.StatusOK, "drive.html", DriveTemplateData{
 			CurrentPath: path,
-			ParentPath:  filepath.Dir(path),
+			ParentPath:  parentPath(path),
 			ShowBack:    path != "",
 			Entries:     entries,
-		}
-
-		tmpl, err := template.ParseFiles("templates/drive.html")
-		if err != nil {
-			app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Template error")
-			return
-		}
-		tmpl.Execute(c.Writer, data)
+			CSRFToken:   app.pageCSRFToken(c),
+			CSPNonce:    cspNonce(c),
+		})
 		return
 	}
 
-	data, err := os.ReadFile(fullAbs)
+	var data []byte
+	err = traceFS(c.Request.Context(), "read", fullAbs, func() (err error) {
+		data, err = os.ReadFile(fullAbs)
+		return err
+	})
 	if err != nil {
 		app.ErrorJSONResponse(c.Writer, http.StatusInternalServerError, "Unable to read file")
 		return
 	}
 	mimeType := mime.TypeByExtension(filepath.Ext(fullAbs))
 	if mimeType == "" {
-		mimeType = http.DetectContentType(data[:512])
+		mimeType = http.DetectContentType(data[:min(len(data), 512)])
 	}
+	fileHeaders(c, filepath.Base(fullAbs), mimeType)
+	app.Metrics.transfer("download", int64(len(data)))
 	c.Data(http.StatusOK, mimeType, data)
 }
+
+// parentPath returns the listing path one level up, "" being the drive root.
+func parentPath(path string) string {
+	parent := filepath.ToSlash(filepath.Dir(path))
+	if parent == "." {
+		return ""
+	}
+	return parent
+}
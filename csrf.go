@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/pkg"
+)
+
+// csrfHeader carries the page's CSRF token on requests that change
+// something. The pages read it from their csrf-token meta tag.
+const csrfHeader = "X-CSRF-Token"
+
+// csrfToken is the synchronizer token for a login. It is derived from the
+// session family rather than stored, so it survives /refresh, dies with the
+// session and needs no table or extra cookie.
+func csrfToken(sessionID string) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SIGNING_KEY")))
+	mac.Write([]byte("csrf:" + sessionID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// pageCSRFToken is the token to render into a page for the signed in user.
+func (app *Application) pageCSRFToken(c *gin.Context) string {
+	return csrfToken(app.currentUser(c).SessionID)
+}
+
+// csrfSafe reports whether a request authenticated by the ldata cookie may
+// go ahead: reads always, anything else only with the session's token.
+// Bearer tokens are never attached by a browser on its own, so
+// LoginMiddleware only asks this for cookie logins.
+func csrfSafe(c *gin.Context, sessionID string) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+
+	sent := c.GetHeader(csrfHeader)
+	return sent != "" && hmac.Equal([]byte(sent), []byte(csrfToken(sessionID)))
+}
+
+// refreshCSRFSafe is csrfSafe for /refresh and /logout sent with the
+// refresh cookie. The access token may have expired by then, so the token is
+// checked against the family the refresh cookie belongs to. A cookie that
+// matches no session passes, there is nothing for the request to act on.
+func (app *Application) refreshCSRFSafe(c *gin.Context, refreshToken string) (bool, error) {
+	familyID, err := app.Model.UsersORM.RefreshTokenFamily(c.Request.Context(), refreshToken)
+	if errors.Is(err, pkg.ErrInvalidToken) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return csrfSafe(c, familyID), nil
+}
+
+// csrfRejected answers a request that failed the CSRF check.
+func (app *Application) csrfRejected(c *gin.Context) {
+	app.log(c).Warning("Missing or invalid CSRF token on ", c.Request.URL.Path)
+	app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Missing or invalid CSRF token")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/iamgak/go-drive/models"
+)
+
+var csrfMeta = regexp.MustCompile(`<meta name="csrf-token" content="([^"]+)"`)
+
+// newRequest builds a JSON request carrying cookies and, when it isn't
+// empty, the CSRF header.
+func newRequest(method, path, body string, cookies []*http.Cookie, csrf string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	if csrf != "" {
+		req.Header.Set(csrfHeader, csrf)
+	}
+	return req
+}
+
+// pageCSRF reads the CSRF token from the sessions page the way the
+// browser does.
+func pageCSRF(t *testing.T, r http.Handler, cookies []*http.Cookie) string {
+	t.Helper()
+	w := serve(r, newRequest(http.MethodGet, "/account/sessions", "", cookies, ""))
+	match := csrfMeta.FindStringSubmatch(w.Body.String())
+	if w.Code != http.StatusOK || match == nil {
+		t.Fatalf("sessions page: status %d, no csrf-token meta tag", w.Code)
+	}
+	return match[1]
+}
+
+func TestCSRFCookieWrites(t *testing.T) {
+	app, db := newTestApp(t)
+	r := app.InitRouter()
+	createTestUser(t, db, "user@example.com", "secret123")
+
+	cookies := passwordLogin(t, r, "user@example.com", "secret123")
+	token := pageCSRF(t, r, cookies)
+	// the same user signed in elsewhere, a different session family
+	otherToken := pageCSRF(t, r, passwordLogin(t, r, "user@example.com", "secret123"))
+	if token == otherToken {
+		t.Fatal("two logins got the same CSRF token")
+	}
+
+	writes := []struct {
+		method, path, body string
+	}{
+		{http.MethodPost, "/drive/create", `{"save_path":"","folder_name":"a"}`},
+		{http.MethodPut, "/drive/rename", `{"old_path":"a","new_path":"b"}`},
+		{http.MethodDelete, "/drive/delete", `{"path":"b"}`},
+		{http.MethodDelete, "/api/sessions", ``},
+	}
+	for _, tt := range writes {
+		for name, csrf := range map[string]string{"no token": "", "another session's token": otherToken, "garbage": "x"} {
+			if w := serve(r, newRequest(tt.method, tt.path, tt.body, cookies, csrf)); w.Code != http.StatusForbidden {
+				t.Errorf("%s %s with %s: status %d, want 403", tt.method, tt.path, name, w.Code)
+			}
+		}
+		if w := serve(r, newRequest(tt.method, tt.path, tt.body, cookies, token)); w.Code != http.StatusOK {
+			t.Errorf("%s %s with the token: status %d: %s", tt.method, tt.path, w.Code, w.Body)
+		}
+	}
+
+	// reads don't need it
+	if w := serve(r, newRequest(http.MethodGet, "/api/activity", "", cookies, "")); w.Code != http.StatusOK {
+		t.Errorf("GET /api/activity without a token: status %d", w.Code)
+	}
+}
+
+func TestCSRFBearerExempt(t *testing.T) {
+	app, db := newTestApp(t)
+	r := app.InitRouter()
+	user := createTestUser(t, db, "user@example.com", "secret123")
+
+	pat, err := app.Model.UsersORM.CreateAccessToken(context.Background(), user.ID, "script", []string{models.ScopeDriveWrite}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an access token from /refresh, for API clients
+	refresh := cookieNamed(passwordLogin(t, r, "user@example.com", "secret123"), refreshCookie)
+	w := serve(r, newRequest(http.MethodPost, "/refresh", `{"refresh_token":"`+refresh.Value+`"}`, nil, ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh with the token in the body: status %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Message models.AuthTokens `json:"message"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, bearer := range map[string]string{"access token": pat.Token, "session JWT": resp.Message.AccessToken} {
+		req := newRequest(http.MethodPost, "/drive/create", `{"save_path":"","folder_name":"`+name+`"}`, nil, "")
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		if w := serve(r, req); w.Code != http.StatusOK {
+			t.Errorf("POST /drive/create with a %s and no CSRF token: status %d: %s", name, w.Code, w.Body)
+		}
+	}
+}
+
+func TestCSRFRefreshLogout(t *testing.T) {
+	app, db := newTestApp(t)
+	r := app.InitRouter()
+	createTestUser(t, db, "user@example.com", "secret123")
+
+	cookies := passwordLogin(t, r, "user@example.com", "secret123")
+	token := pageCSRF(t, r, cookies)
+	otherToken := pageCSRF(t, r, passwordLogin(t, r, "user@example.com", "secret123"))
+	refreshOnly := []*http.Cookie{cookieNamed(cookies, refreshCookie)}
+	accessOnly := []*http.Cookie{cookieNamed(cookies, accessCookie)}
+
+	for _, path := range []string{"/refresh", "/logout"} {
+		for name, csrf := range map[string]string{"no token": "", "another session's token": otherToken} {
+			if w := serve(r, newRequest(http.MethodPost, path, "", refreshOnly, csrf)); w.Code != http.StatusForbidden {
+				t.Errorf("%s with the rdata cookie and %s: status %d, want 403", path, name, w.Code)
+			}
+		}
+	}
+	// logout falls back to the ldata cookie
+	for name, csrf := range map[string]string{"no token": "", "another session's token": otherToken} {
+		if w := serve(r, newRequest(http.MethodPost, "/logout", "", accessOnly, csrf)); w.Code != http.StatusForbidden {
+			t.Errorf("/logout with the ldata cookie and %s: status %d, want 403", name, w.Code)
+		}
+	}
+
+	w := serve(r, newRequest(http.MethodPost, "/refresh", "", refreshOnly, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("/refresh with the token: status %d: %s", w.Code, w.Body)
+	}
+	// rotated, but the family and so the token stay the same
+	cookies = w.Result().Cookies()
+	if w := serve(r, newRequest(http.MethodPost, "/logout", "", cookies, token)); w.Code != http.StatusOK {
+		t.Fatalf("/logout with the token: status %d: %s", w.Code, w.Body)
+	}
+	if w := serve(r, newRequest(http.MethodGet, "/api/sessions", "", cookies, "")); w.Code != http.StatusUnauthorized {
+		t.Errorf("session still usable after logout: status %d", w.Code)
+	}
+}
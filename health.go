@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// commit and buildTime fall back to what the go toolchain stamped into the
+// binary.
+var (
+	version   = "dev"
+	commit    string
+	buildTime string
+)
+
+// probePaths are for orchestrators and stay reachable in maintenance mode.
+var probePaths = []string{"/healthz", "/readyz", "/version"}
+
+const readyTimeout = 2 * time.Second
+
+// Healthz only says the process is up and serving.
+func (app *Application) Healthz(c *gin.Context) {
+	app.sendJSONResponse(c.Writer, http.StatusOK, "ok")
+}
+
+// Readyz reports whether this instance can take traffic: the database
+// answers, the drive root is writable and the schema is up to date.
+func (app *Application) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyTimeout)
+	defer cancel()
+
+	checks := map[string]string{}
+	ready := true
+	check := func(name string, err error) {
+		if err != nil {
+			// details stay in the log, the endpoint is public
+			app.log(c).Warn("Readiness check ", name, " failed: ", err)
+			checks[name] = "failed"
+			ready = false
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	dbErr := app.Model.UsersORM.Ping(ctx)
+	check("database", dbErr)
+	check("storage", storageWritable(app.DriveRoot))
+	if dbErr == nil {
+		check("migrations", app.Model.UsersORM.SchemaCurrent(ctx))
+	} else {
+		checks["migrations"] = "skipped"
+		ready = false
+	}
+
+	if !ready {
+		app.ErrorJSONResponse(c.Writer, http.StatusServiceUnavailable, checks)
+		return
+	}
+	app.sendJSONResponse(c.Writer, http.StatusOK, checks)
+}
+
+// Version reports what build is running.
+func (app *Application) Version(c *gin.Context) {
+	info := gin.H{
+		"version":    version,
+		"commit":     commit,
+		"build_time": buildTime,
+		"go_version": runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if commit == "" {
+					info["commit"] = s.Value
+				}
+			case "vcs.time":
+				if buildTime == "" {
+					info["build_time"] = s.Value
+				}
+			case "vcs.modified":
+				info["modified"] = s.Value == "true"
+			}
+		}
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, info)
+}
+
+// storageWritable creates and removes a temp file in the drive root. The
+// upload temp prefix keeps a leftover from a crash for
+// cleanupOrphanedUploads.
+func storageWritable(root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(root, uploadTempPrefix+"readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	if err := f.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+	return os.Remove(name)
+}
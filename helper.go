@@ -2,17 +2,25 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg"
+	"github.com/iamgak/go-drive/pkg/safepath"
 )
 
-func (app *Application) ServerError(w http.ResponseWriter, err error) {
-	app.Logger.Error("Internal Server Error: ", err)
-	app.sendJSONResponse(w, http.StatusInternalServerError, "Internal Server Error")
+func (app *Application) ServerError(c *gin.Context, err error) {
+	app.log(c).Error("Internal Server Error: ", err)
+	app.sendJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
 }
 
-func (app *Application) CustomError(w http.ResponseWriter, status int, msg string) {
-	app.Logger.Error("Internal Server Error: ", msg)
-	app.ErrorJSONResponse(w, status, msg)
+func (app *Application) CustomError(c *gin.Context, status int, msg string) {
+	app.log(c).Error("Internal Server Error: ", msg)
+	app.ErrorJSONResponse(c.Writer, status, msg)
 }
 
 func (app *Application) sendJSONResponse(w http.ResponseWriter, statusCode int, message any) {
@@ -36,3 +44,68 @@ func (app *Application) ErrorJSONResponse(w http.ResponseWriter, statusCode int,
 
 	json.NewEncoder(w).Encode(resp)
 }
+
+// userRoot makes sure the authenticated user's drive directory exists and
+// returns a resolver confined to it.
+func (app *Application) userRoot(c *gin.Context) (*safepath.Root, error) {
+	baseDir := app.currentUser(c).BaseDir
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		return nil, err
+	}
+
+	return safepath.New(baseDir)
+}
+
+const maxPerPage = 100
+
+// pagination reads ?page= and ?per_page=, defaulting to the first 25.
+func pagination(c *gin.Context) (page, perPage int) {
+	page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ = strconv.Atoi(c.DefaultQuery("per_page", "25"))
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 || perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+	return page, perPage
+}
+
+// checkQuota returns ErrQuotaExceeded if adding size bytes would take the
+// user past their storage quota.
+func (app *Application) checkQuota(c *gin.Context, size int64) error {
+	user := app.currentUser(c)
+	quota, err := app.Model.UsersORM.UserQuota(c.Request.Context(), user.ID)
+	if err != nil || quota == 0 {
+		return err
+	}
+
+	used, err := tracedDirUsage(c.Request.Context(), user.BaseDir)
+	if err != nil {
+		return err
+	}
+	if used+size > quota {
+		return pkg.ErrQuotaExceeded
+	}
+	return nil
+}
+
+// recordEvent writes e to the activity log. A failure is logged but doesn't
+// fail the request, the action itself already happened.
+func (app *Application) recordEvent(c *gin.Context, e models.Event) {
+	if err := app.Model.UsersORM.RecordEvent(c.Request.Context(), e); err != nil {
+		app.log(c).Error("Error saving ", e.Action, " activity: ", err)
+	}
+}
+
+// pathError answers a failed safepath lookup with the matching status code.
+func (app *Application) pathError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, pkg.ErrPathEscape):
+		app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access denied")
+	case errors.Is(err, pkg.ErrInvalidFilename):
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Invalid file or folder name")
+	default:
+		app.ServerError(c, err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg/mailer"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestApp returns the application on a freshly migrated SQLite database,
+// see newTestDB.
+func newTestApp(t *testing.T) (*Application, *gorm.DB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	db, dir := newTestDB(t)
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	if _, err := migrateUp(context.Background(), db, log); err != nil {
+		t.Fatal(err)
+	}
+
+	rateLimits, err := loadRateLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := mailer.LoadTemplates("templates/email")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driveRoot := filepath.Join(dir, "drive")
+	app := &Application{
+		Model:         models.Constructor(db, log),
+		Logger:        log,
+		DriveRoot:     driveRoot,
+		BaseURL:       "http://localhost",
+		Mailer:        &mailer.LogMailer{Logger: log},
+		MailTemplates: templates,
+		Metrics:       newMetrics(driveRoot),
+		RateLimits:    rateLimits,
+	}
+	// mail goes out in the background, let it finish before the database
+	// is closed
+	t.Cleanup(app.bg.Wait)
+	return app, db
+}
+
+// newTestDB opens an empty SQLite database and moves into a temporary
+// working directory holding it, the .env the models look for and the
+// templates the router loads, and returns the directory.
+func newTestDB(t *testing.T) (*gorm.DB, string) {
+	t.Helper()
+	t.Setenv("SIGNING_KEY", "test-signing-key")
+	// as openDBORM does for SQLite, see dbDialector
+	time.Local = time.UTC
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(wd, "templates"), filepath.Join(dir, "templates")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	path := filepath.Join(dir, "test.db")
+	db, err := gorm.Open(sqlite.Open(path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate"), &gorm.Config{
+		Logger:  logger.Discard,
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db, dir
+}
+
+// createTestUser inserts an active account with password.
+func createTestUser(t *testing.T, db *gorm.DB, email, password string) models.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: email, HashPassw: string(hash), Active: true, VerifiedAt: time.Now()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// serve sends req through r and returns the recorded response.
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// passwordLogin signs in through POST /login and returns its cookies.
+func passwordLogin(t *testing.T, r http.Handler, email, password string) []*http.Cookie {
+	t.Helper()
+	body := `{"email":"` + email + `","password":"` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := serve(r, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login as %s: status %d: %s", email, w.Code, w.Body)
+	}
+	return w.Result().Cookies()
+}
+
+// cookieNamed returns the cookie called name, or nil.
+func cookieNamed(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
@@ -1,43 +1,173 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"net"
+	"net/url"
 	"os"
+	"time"
 
+	"github.com/glebarez/sqlite"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/iamgak/go-drive/models"
 	"github.com/iamgak/go-drive/pkg"
+	"github.com/iamgak/go-drive/pkg/migrate"
 	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
-// create a connection
+// create a connection to the database DB_CONNECTION names: mysql (the
+// default), postgres or sqlite
 func openDBORM() (*gorm.DB, error) {
 	err := godotenv.Load()
 	if err != nil {
 		return nil, pkg.ErrNoEnvFileFound
 	}
 
+	dialector, err := dbDialector(os.Getenv("DB_CONNECTION"))
+	if err != nil {
+		return nil, err
+	}
+
+	return gorm.Open(dialector, &gorm.Config{
+		// timestamps are stored in UTC whatever the server's zone
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+}
+
+func dbDialector(connection string) (gorm.Dialector, error) {
+	if connection == "sqlite" {
+		// SQLite keeps times as text and compares them as strings, which
+		// only orders correctly when every time is written in one zone
+		time.Local = time.UTC
+
+		// _txlock=immediate takes the write lock when a transaction begins,
+		// so two of them can't deadlock upgrading from a read; busy_timeout
+		// waits for that lock instead of failing at once
+		path := pkg.EnvString("DB_DATABASE", "go-drive.db")
+		return sqlite.Open(path + "?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate"), nil
+	}
+
 	dbUser := os.Getenv("DB_USERNAME")
 	dbName := os.Getenv("DB_DATABASE")
 	dbPassword := os.Getenv("DB_PASSWORD")
 	if dbUser == "" || dbName == "" || dbPassword == "" {
 		return nil, pkg.ErrNoEnvFileFound
 	}
+	dbHost := pkg.EnvString("DB_HOST", "127.0.0.1")
+
+	switch connection {
+	case "", "mysql":
+		cfg := mysqldriver.NewConfig()
+		cfg.User = dbUser
+		cfg.Passwd = dbPassword
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(dbHost, pkg.EnvString("DB_PORT", "3306"))
+		cfg.DBName = dbName
+		cfg.ParseTime = true
+		return mysql.Open(cfg.FormatDSN()), nil
+
+	case "postgres", "pgsql":
+		dsn := url.URL{
+			Scheme: "postgres",
+			User:   url.UserPassword(dbUser, dbPassword),
+			Host:   net.JoinHostPort(dbHost, pkg.EnvString("DB_PORT", "5432")),
+			Path:   "/" + dbName,
+		}
+		// disable, require, verify-full...; the driver's default otherwise
+		if mode := os.Getenv("DB_SSLMODE"); mode != "" {
+			dsn.RawQuery = url.Values{"sslmode": {mode}}.Encode()
+		}
+		return postgres.Open(dsn.String()), nil
+	}
 
-	dsn := fmt.Sprintf("%s:%s@/%s?parseTime=true", dbUser, dbPassword, dbName)
-	return gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	return nil, fmt.Errorf("unknown DB_CONNECTION %q, expected mysql, postgres or sqlite", connection)
+}
+
+// MigrateDB applies pending migrations as the server starts. With
+// MIGRATE_ON_START=false that's left to `go-drive migrate up` in the deploy,
+// and the server only warns while some are pending.
+func MigrateDB(DB *gorm.DB, logger *logrus.Logger) {
+	ctx := context.Background()
+	if os.Getenv("MIGRATE_ON_START") == "false" {
+		migrator, err := models.NewMigrator(DB)
+		if err != nil {
+			logger.Fatal("Migration failed: ", err)
+		}
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			logger.Error("Error checking migrations: ", err)
+		} else if len(pending) > 0 {
+			logger.Warnf("%d migrations pending, from %s; run go-drive migrate up", len(pending), pending[0])
+		}
+		return
+	}
+
+	applied, err := migrateUp(ctx, DB, logger)
+	for _, mig := range applied {
+		logger.Info("Applied migration ", mig)
+	}
+	if err != nil {
+		logger.Fatal("Migration failed: ", err)
+	}
 }
 
-// create migration
-func MigrateDB(DB *gorm.DB) {
-	err := DB.AutoMigrate(
-		&models.User{},
-		&models.UsersSession{},
-		&models.UserActivityLog{},
-	)
+// migrateUp adopts a database from before versioned migrations, then
+// applies the pending ones and returns them.
+func migrateUp(ctx context.Context, DB *gorm.DB, logger *logrus.Logger) ([]migrate.Migration, error) {
+	migrator, err := models.NewMigrator(DB)
 	if err != nil {
-		log.Fatal("Migration failed:", err)
+		return nil, err
 	}
+	if err := adoptAutoMigrated(ctx, DB, migrator, logger); err != nil {
+		return nil, err
+	}
+	return migrator.Up(ctx)
+}
+
+// adoptAutoMigrated takes over a database whose schema AutoMigrate kept,
+// before there were migrations: it gets the last AutoMigrate and data fixes
+// it would have had at startup, and is then recorded at 0001, which creates
+// the same tables.
+func adoptAutoMigrated(ctx context.Context, DB *gorm.DB, migrator *migrate.Migrator, logger *logrus.Logger) error {
+	if migrator.Initialized(ctx) || !DB.Migrator().HasTable(&models.User{}) {
+		return nil
+	}
+	logger.Info("Adopting a database from before versioned migrations")
+
+	// users_sessions used to hold raw login JWTs; those rows can't be turned
+	// into hashed refresh tokens, so the old table is dropped and recreated
+	if DB.Migrator().HasColumn(&models.UsersSession{}, "login_token") {
+		if err := DB.Migrator().DropTable(&models.UsersSession{}); err != nil {
+			return err
+		}
+	}
+
+	if err := DB.AutoMigrate(models.Tables()...); err != nil {
+		return err
+	}
+
+	// activity rows from before typed events only have their text
+	converted, err := models.MigrateActivityEvents(DB)
+	if err != nil {
+		return err
+	}
+	if converted > 0 {
+		logger.Infof("Converted %d activity rows to typed events", converted)
+	}
+
+	// and from before the log was hash chained
+	chained, err := models.ChainActivityLog(DB)
+	if err != nil {
+		return err
+	}
+	if chained > 0 {
+		logger.Infof("Hash chained %d existing activity rows", chained)
+	}
+
+	return migrator.Baseline(ctx, 1)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/iamgak/go-drive/models"
+	"github.com/sirupsen/logrus"
+)
+
+// legacySchema is the schema AutoMigrate kept on SQLite before there were
+// migrations, with rows from that time.
+const legacySchema = `
+CREATE TABLE users (id integer PRIMARY KEY AUTOINCREMENT, email text NOT NULL UNIQUE, hash_passw text NOT NULL, activation_token text, active numeric DEFAULT false, verified_at datetime DEFAULT NULL, created_at datetime DEFAULT CURRENT_TIMESTAMP, updated_at datetime DEFAULT NULL);
+CREATE INDEX idx_users_activation_token ON users (activation_token);
+CREATE TABLE users_sessions (id integer PRIMARY KEY AUTOINCREMENT, user_id integer, login_token text NOT NULL, created_at datetime DEFAULT CURRENT_TIMESTAMP);
+CREATE INDEX idx_users_sessions_user_id ON users_sessions (user_id);
+CREATE TABLE user_activity_logs (id integer PRIMARY KEY AUTOINCREMENT, user_id integer, activity text NOT NULL, superseded numeric DEFAULT 0, ip_addr text DEFAULT NULL, created_at datetime DEFAULT CURRENT_TIMESTAMP, updated_at datetime DEFAULT NULL);
+CREATE INDEX idx_user_activity_logs_user_id ON user_activity_logs (user_id);
+
+INSERT INTO users (email, hash_passw, active, verified_at) VALUES ('old@example.com', 'x', true, CURRENT_TIMESTAMP);
+INSERT INTO users_sessions (user_id, login_token) VALUES (1, 'eyJhbGciOiJIUzI1NiJ9.raw.jwt');
+INSERT INTO user_activity_logs (user_id, activity, ip_addr) VALUES (1, 'Logged In', '127.0.0.1');
+INSERT INTO user_activity_logs (user_id, activity, ip_addr) VALUES (1, 'File Renamed: a.txt to b.txt ', '127.0.0.1');
+INSERT INTO user_activity_logs (user_id, activity, ip_addr) VALUES (1, 'File Deleted: /home/iamgak/drive/1/new/b.txt ', '127.0.0.1');
+`
+
+func TestMigrateUpAdoptsAutoMigrated(t *testing.T) {
+	ctx := context.Background()
+	db, _ := newTestDB(t)
+	if err := db.Exec(legacySchema).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	applied, err := migrateUp(ctx, db, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 0001 would fail on the tables already there
+	if len(applied) != 1 || applied[0].Version != 2 {
+		t.Fatalf("applied %v, want only 0002", applied)
+	}
+
+	migrator, err := models.NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := migrator.Pending(ctx); err != nil || len(pending) != 0 {
+		t.Fatalf("pending %v, %v", pending, err)
+	}
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			t.Errorf("%s not recorded", s.Migration)
+		}
+	}
+
+	var user models.User
+	if err := db.First(&user, "email = ?", "old@example.com").Error; err != nil {
+		t.Fatalf("the account didn't survive: %v", err)
+	}
+	if db.Migrator().HasColumn(&models.UsersSession{}, "login_token") {
+		t.Error("users_sessions still has login_token")
+	}
+	var sessions int64
+	if err := db.Model(&models.UsersSession{}).Count(&sessions).Error; err != nil || sessions != 0 {
+		t.Errorf("%d sessions (%v), want the raw JWTs gone", sessions, err)
+	}
+
+	var logs []models.UserActivityLog
+	if err := db.Order("id").Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 3 || logs[0].Action != models.ActionLogin ||
+		logs[1].Action != models.ActionFileRenamed || logs[1].OldPath != "a.txt" || logs[1].TargetPath != "b.txt" ||
+		logs[2].Action != models.ActionFileDeleted || logs[2].TargetPath != "new/b.txt" {
+		t.Fatalf("activity rows not converted: %+v", logs)
+	}
+	checked, broken, err := models.Constructor(db, log).UsersORM.VerifyActivityChain(ctx)
+	if err != nil || broken != nil || checked != 3 {
+		t.Fatalf("VerifyActivityChain = %d, %+v, %v", checked, broken, err)
+	}
+
+	// the next start finds it adopted
+	if applied, err := migrateUp(ctx, db, log); err != nil || len(applied) != 0 {
+		t.Fatalf("second migrateUp = %v, %v", applied, err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg"
+	"github.com/iamgak/go-drive/pkg/ratelimit"
+)
+
+// loginIPBlocked answers 429 and returns true once the client IP has used up
+// its failed logins, across all accounts, so spraying one password over many
+// emails is slowed down as well.
+func (app *Application) loginIPBlocked(c *gin.Context) bool {
+	limit := app.RateLimits.limits["login_failures"]
+	res, err := ratelimit.Exceeded(c.Request.Context(), app.RateLimits.store, "login_failures:ip:"+c.ClientIP(), limit)
+	if err != nil {
+		app.log(c).Error("Rate limiter unavailable, letting login through: ", err)
+		return false
+	}
+	if res.Allowed {
+		return false
+	}
+
+	app.log(c).Warn("Too many failed logins from ", c.ClientIP())
+	app.Metrics.login("password", false)
+	app.retryAfter(c, res.Reset)
+	app.ErrorJSONResponse(c.Writer, http.StatusTooManyRequests, "Too many failed logins, try again later")
+	return true
+}
+
+// loginIPFailed counts a failed login against the client IP.
+func (app *Application) loginIPFailed(c *gin.Context) {
+	limit := app.RateLimits.limits["login_failures"]
+	if _, err := ratelimit.Allow(c.Request.Context(), app.RateLimits.store, "login_failures:ip:"+c.ClientIP(), limit); err != nil {
+		app.log(c).Error("Error counting failed login: ", err)
+	}
+}
+
+// loginFailed answers a failed password login: 429 while the account has
+// to wait between attempts, 423 while it is locked, mailing the owner when
+// this attempt locked it, and 400 for plain wrong credentials. Each one
+// counts against the client IP, attempts on a blocked account too, or
+// guesses at accounts already locked would never slow the IP down.
+func (app *Application) loginFailed(c *gin.Context, err error) {
+	app.Metrics.login("password", false)
+	app.loginIPFailed(c)
+
+	var blocked *models.LoginBlockedError
+	if !errors.As(err, &blocked) {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	app.retryAfter(c, time.Until(blocked.Until))
+	if !blocked.Locked {
+		app.ErrorJSONResponse(c.Writer, http.StatusTooManyRequests, "Too many failed logins, wait before trying again")
+		return
+	}
+
+	if blocked.Notice != nil {
+		app.sendMail(blocked.Notice.Email, "Your Drive account was locked", "account_locked", map[string]any{
+			"Link":  app.BaseURL + "/unlock/" + blocked.Notice.UnlockToken,
+			"Until": blocked.Notice.Until,
+			"IP":    c.ClientIP(),
+		})
+	}
+	app.ErrorJSONResponse(c.Writer, http.StatusLocked, "Account locked after too many failed logins, see your email to unlock it")
+}
+
+func (app *Application) retryAfter(c *gin.Context, d time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(max(int(math.Ceil(d.Seconds())), 1)))
+}
+
+// UnlockAccount is the link in the lockout email.
+func (app *Application) UnlockAccount(c *gin.Context) {
+	err := app.Model.UsersORM.UnlockAccount(c.Request.Context(), c.Param("token"))
+	if errors.Is(err, pkg.ErrInvalidToken) {
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "Unlock link is invalid or was already used")
+		return
+	}
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Account Unlocked, you can log in again")
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg/ratelimit"
+)
+
+func TestLoginFailuresCountPerIP(t *testing.T) {
+	app, db := newTestApp(t)
+	app.RateLimits.limits["login_failures"] = ratelimit.Limit{Requests: 3, Window: time.Minute}
+	r := app.InitRouter()
+	createTestUser(t, db, "open@example.com", "secret123")
+	locked := createTestUser(t, db, "locked@example.com", "secret123")
+	until := time.Now().Add(time.Hour)
+	if err := db.Model(&models.User{}).Where("id = ?", locked.ID).Update("locked_until", until).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(ip, email, password string) int {
+		body := `{"email":"` + email + `","password":"` + password + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/login", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = ip + ":40000"
+		return serve(r, req).Code
+	}
+
+	// guesses at a locked account are answered 423, but still use up the IP's
+	// failed logins
+	for i := 0; i < 3; i++ {
+		if code := login("10.0.0.1", "locked@example.com", "guess"); code != http.StatusLocked {
+			t.Fatalf("attempt %d on the locked account: status %d, want 423", i+1, code)
+		}
+	}
+	if code := login("10.0.0.1", "open@example.com", "secret123"); code != http.StatusTooManyRequests {
+		t.Errorf("login after the IP's failures are used up: status %d, want 429", code)
+	}
+
+	// another IP isn't affected, and wrong passwords count as before
+	if code := login("10.0.0.2", "open@example.com", "secret123"); code != http.StatusOK {
+		t.Errorf("login from another IP: status %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := login("10.0.0.3", "open@example.com", "wrong-password"); code != http.StatusBadRequest {
+			t.Fatalf("wrong password %d: status %d, want 400", i+1, code)
+		}
+	}
+	if code := login("10.0.0.3", "open@example.com", "secret123"); code != http.StatusTooManyRequests {
+		t.Errorf("login after wrong passwords used up the IP's failures: status %d, want 429", code)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// logEntryKey holds the request's *logrus.Entry, on the gin context and on
+// the request context where the models pick it up.
+const logEntryKey = "logger"
+
+// validRequestID limits what an incoming X-Request-ID may be, so a client
+// can't inject anything odd into the logs or activity rows.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// newLogger is configured by LOG_FORMAT (json or text) and LOG_LEVEL
+// (debug, info, warn, error).
+func newLogger() *logrus.Logger {
+	logger := logrus.New()
+	if os.Getenv("LOG_FORMAT") == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	} else {
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	}
+
+	logger.SetLevel(logrus.InfoLevel)
+	if level, err := logrus.ParseLevel(os.Getenv("LOG_LEVEL")); err == nil {
+		logger.SetLevel(level)
+	}
+	return logger
+}
+
+// RequestIDMiddleware gives every request an id, the caller's X-Request-ID
+// when it sent a sensible one, echoes it back, and starts the request's log
+// entry with it.
+func (app *Application) RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(requestIDHeader, id)
+
+		fields := logrus.Fields{
+			"request_id": id,
+			"method":     c.Request.Method,
+			"route":      c.FullPath(),
+		}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			fields["trace_id"] = sc.TraceID().String()
+		}
+
+		ctx := context.WithValue(c.Request.Context(), "request_id", id)
+		c.Request = c.Request.WithContext(ctx)
+		c.Set("request_id", id)
+		app.setLogEntry(c, app.Logger.WithFields(fields))
+		c.Next()
+	}
+}
+
+// AccessLog writes one line per request, in place of gin.Logger, through
+// the request's entry so it carries the same fields as everything else the
+// request logged.
+func (app *Application) AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		entry := app.log(c).WithFields(logrus.Fields{
+			"status":     status,
+			"path":       c.Request.URL.Path,
+			"latency_ms": time.Since(start).Milliseconds(),
+			"client_ip":  c.ClientIP(),
+			"bytes":      c.Writer.Size(),
+		})
+		switch {
+		case status >= 500:
+			entry.Error("request")
+		case status >= 400:
+			entry.Warn("request")
+		case slices.Contains(probePaths, c.Request.URL.Path):
+			// probes come every few seconds and would drown everything else
+			entry.Debug("request")
+		default:
+			entry.Info("request")
+		}
+	}
+}
+
+// log returns the request's log entry, or a plain one outside a request.
+func (app *Application) log(c *gin.Context) *logrus.Entry {
+	if entry, ok := c.Value(logEntryKey).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(app.Logger)
+}
+
+// addLogFields adds fields to the rest of the request's log lines, e.g. the
+// user id once LoginMiddleware knows it.
+func (app *Application) addLogFields(c *gin.Context, fields logrus.Fields) {
+	app.setLogEntry(c, app.log(c).WithFields(fields))
+}
+
+func (app *Application) setLogEntry(c *gin.Context, entry *logrus.Entry) {
+	c.Set(logEntryKey, entry)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), logEntryKey, entry))
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// background runs fn outside the request, e.g. sending email, and lets
+// serve wait for it during shutdown instead of cutting it off.
+func (app *Application) background(fn func()) {
+	app.bg.Add(1)
+	go func() {
+		defer app.bg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.Logger.Error("Background task panic: ", err)
+			}
+		}()
+		fn()
+	}()
+}
+
+// sendMail renders the named email template and sends it in the background;
+// failures are logged since the request has already been answered.
+func (app *Application) sendMail(to, subject, template string, data any) {
+	msg, err := app.MailTemplates.Render(to, subject, template, data)
+	if err != nil {
+		app.Logger.Error("Error rendering email ", template, ": ", err)
+		return
+	}
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := app.Mailer.Send(ctx, msg); err != nil {
+			app.Logger.Error("Error sending email ", template, " to ", to, ": ", err)
+		}
+	})
+}
+
+// sendActivationMail emails the link that activates a new account.
+func (app *Application) sendActivationMail(email, token string, expiresAt time.Time) {
+	app.sendMail(email, "Activate your Drive account", "activation", map[string]any{
+		"Link":      app.BaseURL + "/activation_token/" + token,
+		"ExpiresAt": expiresAt,
+	})
+}
@@ -1,56 +1,172 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg"
+	"github.com/iamgak/go-drive/pkg/audit"
+	"github.com/iamgak/go-drive/pkg/mailer"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 	"github.com/sirupsen/logrus"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
 type Application struct {
-	Model           *models.Init
-	UserID          uint
-	isAuthenticated bool
-	Email           string
-	Logger          *logrus.Logger
-	BaseDir         string
+	Model         *models.Init
+	Logger        *logrus.Logger
+	DriveRoot     string
+	TLSEnabled    bool
+	HSTSMaxAge    int
+	BaseURL       string // public URL used for links in emails
+	Mailer        mailer.Mailer
+	MailTemplates *mailer.Templates
+	OIDCProviders []*oidcProvider
+	Audit         auditConfig
+	Metrics       *metrics
+	RateLimits    *rateLimits
+	bg            sync.WaitGroup
 }
 
 func main() {
-	var logrusLogger = logrus.New()
-	logrusLogger.SetFormatter(&logrus.TextFormatter{}) // Use JSON format for structured logging
-	logrusLogger.SetLevel(logrus.InfoLevel)            // Log Info, Warning, and Error
-
-	logrusLogger.Info("Task Web App startet \n")
-	err := godotenv.Load()
-	if err != nil {
-		logrusLogger.Error("Failed to get task: ", err)
-		log.Fatal("Error loading .env file:", err)
+	// .env may set LOG_FORMAT and LOG_LEVEL, so it's read before the logger
+	// is built
+	envErr := godotenv.Load()
+	logrusLogger := newLogger()
+	if envErr != nil {
+		logrusLogger.Fatal("Error loading .env file: ", envErr)
 	}
+	logrusLogger.Info("Task Web App started")
 
 	Port := os.Getenv("PORT")
 	addr := flag.String("addr", ":"+Port, "HTTP network address")
 	flag.Parse()
 
+	if flag.Arg(0) == "audit-keygen" {
+		key, err := audit.GenerateKey()
+		if err != nil {
+			logrusLogger.Fatal(err)
+		}
+		fmt.Println(key)
+		return
+	}
+	if flag.Arg(0) == "migrate" && flag.Arg(1) == "create" {
+		os.Exit(createMigration(flag.Args()[2:]))
+	}
+
 	dbORM, err := openDBORM()
 	if err != nil {
-		logrusLogger.Error("Error creating db connection : ", err)
-		log.Fatal(err)
+		logrusLogger.Fatal("Error creating db connection : ", err)
+	}
+
+	driveRoot := os.Getenv("DRIVE_ROOT")
+	if driveRoot == "" {
+		driveRoot = "drive"
 	}
 
 	app := Application{
-		Model:  models.Constructor(dbORM, logrusLogger),
-		Logger: logrusLogger,
+		Model:     models.Constructor(dbORM, logrusLogger),
+		Logger:    logrusLogger,
+		DriveRoot: driveRoot,
+		Metrics:   newMetrics(driveRoot),
+	}
+
+	if err := dbORM.Use(gormMetrics{app.Metrics}); err != nil {
+		logrusLogger.Fatal("Error registering query metrics : ", err)
+	}
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		logrusLogger.Fatal("Error configuring tracing : ", err)
+	}
+	// query parameters hold password hashes and tokens, keep them out of spans
+	if err := dbORM.Use(tracing.NewPlugin(tracing.WithoutMetrics(), tracing.WithoutQueryVariables())); err != nil {
+		logrusLogger.Fatal("Error registering query tracing : ", err)
+	}
+
+	app.Audit, err = loadAuditConfig()
+	if err != nil {
+		logrusLogger.Fatal("Error configuring audit checkpoints : ", err)
 	}
 
-	MigrateDB(dbORM)
+	switch flag.Arg(0) {
+	case "":
+	case "verify-audit":
+		os.Exit(app.verifyAudit(context.Background()))
+	case "migrate":
+		os.Exit(migrateCommand(context.Background(), dbORM, logrusLogger, flag.Args()[1:]))
+	default:
+		logrusLogger.Fatalf("unknown command %q, expected migrate, verify-audit or audit-keygen", flag.Arg(0))
+	}
+
+	app.RateLimits, err = loadRateLimits()
+	if err != nil {
+		logrusLogger.Fatal("Error configuring rate limits : ", err)
+	}
+
+	app.Mailer, err = mailer.New(mailer.Config{
+		Driver:   os.Getenv("MAIL_DRIVER"),
+		Host:     os.Getenv("MAIL_HOST"),
+		Port:     os.Getenv("MAIL_PORT"),
+		Username: os.Getenv("MAIL_USERNAME"),
+		Password: os.Getenv("MAIL_PASSWORD"),
+		From:     os.Getenv("MAIL_FROM"),
+	}, logrusLogger)
+	if err != nil {
+		logrusLogger.Fatal("Error configuring mailer : ", err)
+	}
+
+	app.MailTemplates, err = mailer.LoadTemplates("templates/email")
+	if err != nil {
+		logrusLogger.Fatal("Error loading email templates : ", err)
+	}
+
+	opts := serveOptions{
+		DrainTimeout: pkg.EnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		RedirectAddr: os.Getenv("TLS_REDIRECT_ADDR"),
+		MetricsAddr:  os.Getenv("METRICS_ADDR"),
+	}
+
+	scheme := "http"
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		opts.Certs, err = newCertReloader(certFile, keyFile)
+		if err != nil {
+			logrusLogger.Fatal("Error loading TLS certificate : ", err)
+		}
+
+		app.TLSEnabled = true
+		scheme = "https"
+		app.HSTSMaxAge = 31536000 // one year
+		if v, err := strconv.Atoi(os.Getenv("HSTS_MAX_AGE")); err == nil && v >= 0 {
+			app.HSTSMaxAge = v
+		}
+	}
+
+	app.BaseURL = strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+	if app.BaseURL == "" {
+		app.BaseURL = scheme + "://localhost" + *addr
+	}
+
+	app.OIDCProviders, err = loadOIDCProviders(app.BaseURL)
+	if err != nil {
+		logrusLogger.Fatal("Error configuring OIDC providers : ", err)
+	}
+
+	MigrateDB(dbORM, logrusLogger)
+
+	if err := app.cleanupOrphanedUploads(); err != nil {
+		logrusLogger.Error("Error cleaning up orphaned uploads: ", err)
+	}
 
 	maxHeaderBytes := 1 << 20
 	server := &http.Server{
@@ -61,6 +177,17 @@ func main() {
 		MaxHeaderBytes: maxHeaderBytes,
 	}
 
-	logrusLogger.Info("start http server listening ", *addr)
-	server.ListenAndServe()
+	logrusLogger.Infof("start http server listening %s (tls: %t)", *addr, app.TLSEnabled)
+	serveErr := app.serve(server, opts)
+
+	// send the spans of the last requests before exiting
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		logrusLogger.Error("Error flushing traces: ", err)
+	}
+	cancel()
+
+	if serveErr != nil {
+		logrusLogger.Fatal("Server error: ", serveErr)
+	}
 }
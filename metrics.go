@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// storageScrapeInterval is how long a walk of DriveRoot for the storage
+// gauges is reused; walking it on every scrape would be too slow.
+const storageScrapeInterval = time.Minute
+
+// metrics holds the Prometheus collectors. They live in their own registry
+// so nothing registered by a dependency leaks into /metrics.
+type metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	transfers    *prometheus.CounterVec
+	transferred  *prometheus.CounterVec
+	rateLimited  *prometheus.CounterVec
+	logins       *prometheus.CounterVec
+	dbDuration   *prometheus.HistogramVec
+	dbErrors     *prometheus.CounterVec
+}
+
+func newMetrics(driveRoot string) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by method, gin route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by method and gin route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		transfers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "drive_transfers_total",
+			Help: "Files uploaded and downloaded.",
+		}, []string{"direction"}),
+		transferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "drive_transfer_bytes_total",
+			Help: "Bytes uploaded and downloaded.",
+		}, []string{"direction"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limit_rejections_total",
+			Help: "Requests turned away by the rate limiter, by gin route.",
+		}, []string{"route"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "auth_logins_total",
+			Help: "Login attempts by method (password, 2fa, oidc) and outcome.",
+		}, []string{"method", "outcome"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "GORM statement latency by operation and table.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation", "table"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "GORM statements that failed, by operation and table.",
+		}, []string{"operation", "table"}),
+	}
+
+	usage := &storageUsage{root: driveRoot}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.transfers, m.transferred,
+		m.rateLimited, m.logins, m.dbDuration, m.dbErrors,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "drive_storage_used_bytes",
+			Help: "Bytes stored under DRIVE_ROOT across all users.",
+		}, func() float64 { return float64(usage.get().bytes) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "drive_storage_users",
+			Help: "User folders under DRIVE_ROOT.",
+		}, func() float64 { return float64(usage.get().users) }),
+	)
+	return m
+}
+
+// middleware times every request. Unmatched paths share one route label so
+// scanners can't blow up the series count.
+func (m *metrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *metrics) transfer(direction string, size int64) {
+	m.transfers.WithLabelValues(direction).Inc()
+	m.transferred.WithLabelValues(direction).Add(float64(size))
+}
+
+func (m *metrics) login(method string, ok bool) {
+	outcome := "success"
+	if !ok {
+		outcome = "failure"
+	}
+	m.logins.WithLabelValues(method, outcome).Inc()
+}
+
+// handler serves the registry, behind METRICS_TOKEN when one is set.
+func (m *metrics) handler(token string) http.Handler {
+	h := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	if token == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// metricsRoute mounts /metrics on the main router when it isn't served from
+// METRICS_ADDR. With neither that nor METRICS_TOKEN set it stays off, rather
+// than exposing the numbers to anyone who can reach the site.
+func (app *Application) metricsRoute(r *gin.Engine) {
+	token := os.Getenv("METRICS_TOKEN")
+	if os.Getenv("METRICS_ADDR") != "" {
+		return
+	}
+	if token == "" {
+		app.Logger.Info("/metrics disabled, set METRICS_TOKEN or METRICS_ADDR to enable it")
+		return
+	}
+	r.GET("/metrics", gin.WrapH(app.Metrics.handler(token)))
+}
+
+type storageTotals struct{ bytes, users int64 }
+
+// storageUsage caches the totals behind the storage gauges.
+type storageUsage struct {
+	root string
+
+	mu      sync.Mutex
+	checked time.Time
+	last    storageTotals
+}
+
+func (s *storageUsage) get() storageTotals {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.checked) < storageScrapeInterval {
+		return s.last
+	}
+	s.checked = time.Now()
+
+	// a failed walk keeps the previous numbers rather than dropping to zero
+	if total, err := dirUsage(s.root); err == nil {
+		s.last.bytes = total
+	}
+	if entries, err := os.ReadDir(s.root); err == nil {
+		s.last.users = 0
+		for _, e := range entries {
+			if e.IsDir() {
+				s.last.users++
+			}
+		}
+	}
+	return s.last
+}
+
+// gormMetrics is a GORM plugin timing every statement.
+type gormMetrics struct {
+	m *metrics
+}
+
+const gormMetricsStart = "metrics:start"
+
+func (p gormMetrics) Name() string { return "metrics" }
+
+func (p gormMetrics) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("metrics:before_create", p.before),
+		cb.Create().After("*").Register("metrics:after_create", p.after("create")),
+		cb.Query().Before("*").Register("metrics:before_query", p.before),
+		cb.Query().After("*").Register("metrics:after_query", p.after("query")),
+		cb.Update().Before("*").Register("metrics:before_update", p.before),
+		cb.Update().After("*").Register("metrics:after_update", p.after("update")),
+		cb.Delete().Before("*").Register("metrics:before_delete", p.before),
+		cb.Delete().After("*").Register("metrics:after_delete", p.after("delete")),
+		cb.Row().Before("*").Register("metrics:before_row", p.before),
+		cb.Row().After("*").Register("metrics:after_row", p.after("row")),
+		cb.Raw().Before("*").Register("metrics:before_raw", p.before),
+		cb.Raw().After("*").Register("metrics:after_raw", p.after("raw")),
+	)
+}
+
+func (p gormMetrics) before(db *gorm.DB) {
+	db.InstanceSet(gormMetricsStart, time.Now())
+}
+
+func (p gormMetrics) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.InstanceGet(gormMetricsStart)
+		if !ok {
+			return
+		}
+		start, _ := v.(time.Time)
+
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+		p.m.dbDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			p.m.dbErrors.WithLabelValues(operation, table).Inc()
+		}
+	}
+}
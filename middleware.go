@@ -2,147 +2,251 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
-	"sync"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 	"github.com/iamgak/go-drive/models"
-	"github.com/joho/godotenv"
-	"golang.org/x/time/rate"
+	"github.com/iamgak/go-drive/pkg"
+	"github.com/sirupsen/logrus"
 )
 
-func secureHeaders() gin.HandlerFunc {
-	return (func(c *gin.Context) {
-		// css was not loading SOP
-		// c.Header("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
-		// c.Header("Referrer-Policy", "origin-when-cross-origin")
-		// c.Header("X-Content-Type-Options", "nosniff")
-		// c.Header("X-Frame-Options", "deny")
-		// c.Header("X-XSS-Protection", "0")
-		c.Next()
+// authUser is the identity LoginMiddleware attaches to each request. It lives
+// on the gin context rather than the shared Application so concurrent
+// requests never see each other's user. Requests made with a personal access
+// token carry its TokenID and Scopes instead of a SessionID.
+type authUser struct {
+	ID        uint
+	Email     string
+	SessionID string
+	Role      string
+	TokenID   uint
+	Scopes    []string
+	BaseDir   string
+}
+
+const authUserKey = "auth_user"
+
+// allows reports whether the request may use scope. Browser sessions are
+// not scoped and may do everything.
+func (u *authUser) allows(scope string) bool {
+	return u.TokenID == 0 || slices.Contains(u.Scopes, scope)
+}
+
+// currentUser returns the user LoginMiddleware authenticated for c.
+func (app *Application) currentUser(c *gin.Context) *authUser {
+	user, _ := c.MustGet(authUserKey).(*authUser)
+	return user
+}
+
+// parseAccessToken validates a JWT signed with SIGNING_KEY and returns its claims.
+func (app *Application) parseAccessToken(tokenString string) (*models.MyCustomClaims, error) {
+	SIGNING_KEY := os.Getenv("SIGNING_KEY")
+	if SIGNING_KEY == "" {
+		return nil, pkg.ErrNoEnvFileFound
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &models.MyCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(SIGNING_KEY), nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*models.MyCustomClaims)
+	if !ok || !token.Valid || claims.SessionID == "" {
+		return nil, pkg.ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(c *gin.Context) string {
+	scheme, token, ok := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
 }
+
+// LoginMiddleware accepts the ldata session cookie, or as a bearer token
+// either a personal access token or an access token from /refresh. Cookie
+// logins must also send the CSRF token on anything but reads.
 func (app *Application) LoginMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cookie, err := c.Request.Cookie("ldata")
-		if err != nil || cookie.Value == "" {
-			app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Access Denied")
-			app.Logger.Warning("Missing or empty ldata cookie")
-			c.Abort()
+		token := bearerToken(c)
+		if strings.HasPrefix(token, models.AccessTokenPrefix) {
+			app.personalTokenLogin(c, token)
 			return
 		}
 
-		tokenString := cookie.Value
+		fromCookie := token == ""
+		if fromCookie {
+			cookie, err := c.Request.Cookie(accessCookie)
+			if err != nil || cookie.Value == "" {
+				app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Access Denied")
+				app.log(c).Warning("Missing or empty ldata cookie")
+				c.Abort()
+				return
+			}
+			token = cookie.Value
+		}
 
-		err = godotenv.Load()
-		if err != nil {
-			app.sendJSONResponse(c.Writer, http.StatusInternalServerError, "Internal Server Error")
-			app.Logger.Errorf("Error loading env: %v", err)
+		claims, err := app.parseAccessToken(token)
+		if errors.Is(err, pkg.ErrNoEnvFileFound) {
+			app.sendJSONResponse(c.Writer, http.StatusInternalServerError, "Signing key not found")
+			app.log(c).Error("Missing SIGNING_KEY in env")
 			c.Abort()
 			return
 		}
 
-		SIGNING_KEY := os.Getenv("SIGNING_KEY")
-		if SIGNING_KEY == "" {
-			app.sendJSONResponse(c.Writer, http.StatusInternalServerError, "Signing key not found")
-			app.Logger.Error("Missing SIGNING_KEY in env")
+		if err != nil {
+			app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Invalid Token")
+			app.log(c).Error("Token parse error:", err)
 			c.Abort()
 			return
 		}
 
-		token, err := jwt.ParseWithClaims(tokenString, &models.MyCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(SIGNING_KEY), nil
-		})
-
+		// the JWT itself stays valid until it expires, so logout and reuse
+		// detection only take effect through this server side check
+		active, err := app.Model.UsersORM.SessionActive(c.Request.Context(), claims.SessionID)
 		if err != nil {
-			app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Invalid Token")
-			app.Logger.Error("Token parse error:", err)
+			app.ServerError(c, err)
 			c.Abort()
 			return
 		}
 
-		if claims, ok := token.Claims.(*models.MyCustomClaims); ok && token.Valid {
-			app.UserID = claims.UserID
-			app.Email = claims.Email
-			app.BaseDir = fmt.Sprintf("/home/iamgak/Desktop/info/assignment/go-task-github/drive/%d/", app.UserID)
-			app.isAuthenticated = true
-			c.Next()
-		} else {
-			app.Logger.Warning("Invalid token or claims")
-			app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Invalid Token")
+		if !active {
+			app.log(c).Warning("Token for revoked session used by user ", claims.UserID)
+			app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Session Revoked")
+			c.Abort()
+			return
+		}
+
+		if fromCookie && !csrfSafe(c, claims.SessionID) {
+			app.log(c).Warning("Missing or invalid CSRF token from user ", claims.UserID)
+			app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Missing or invalid CSRF token")
 			c.Abort()
 			return
 		}
+
+		if err := app.Model.UsersORM.TouchSession(c.Request.Context(), claims.SessionID); err != nil {
+			app.log(c).Error("Error updating session last seen: ", err)
+		}
+
+		app.setAuthUser(c, &authUser{
+			ID:        claims.UserID,
+			Email:     claims.Email,
+			SessionID: claims.SessionID,
+			Role:      claims.Role,
+			BaseDir:   app.userBaseDir(claims.UserID),
+		})
+		c.Next()
 	}
 }
 
-func (app *Application) rateLimiter() gin.HandlerFunc {
-	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
-	}
-
-	// Declare a mutex and a map to hold the clients' IP addresses and rate limiters.
-	var (
-		mu      sync.Mutex
-		clients = make(map[string]*client)
-	)
-
-	go func() {
-		for {
-			// it will run until code run but take break every minute laziness
-			time.Sleep(time.Minute)
-			// Lock the mutex to prevent any rate limiter checks from happening while
-			// the cleanup is taking place.
-			mu.Lock()
-			// Loop through all clients. If they haven't been seen within the last three
-			// minutes, delete the corresponding entry from the map.
-			for ip, client := range clients {
-				if time.Since(client.lastSeen) > 3*time.Minute {
-					delete(clients, ip)
-				}
-			}
+func (app *Application) personalTokenLogin(c *gin.Context, token string) {
+	owner, err := app.Model.UsersORM.AuthenticateAccessToken(c.Request.Context(), token)
+	if errors.Is(err, pkg.ErrInvalidToken) {
+		app.sendJSONResponse(c.Writer, http.StatusUnauthorized, "Invalid Token")
+		app.log(c).Warning("Unknown, expired or revoked access token used")
+		c.Abort()
+		return
+	}
+	if err != nil {
+		app.ServerError(c, err)
+		c.Abort()
+		return
+	}
+
+	app.setAuthUser(c, &authUser{
+		ID:      owner.UserID,
+		Email:   owner.Email,
+		Role:    owner.Role,
+		TokenID: owner.TokenID,
+		Scopes:  owner.Scopes,
+		BaseDir: app.userBaseDir(owner.UserID),
+	})
+	c.Next()
+}
 
-			mu.Unlock()
+// setAuthUser attaches user to the request and its log lines.
+func (app *Application) setAuthUser(c *gin.Context, user *authUser) {
+	c.Set(authUserKey, user)
+	fields := logrus.Fields{"user_id": user.ID}
+	if user.TokenID != 0 {
+		fields["token_id"] = user.TokenID
+	}
+	app.addLogFields(c, fields)
+}
+
+func (app *Application) userBaseDir(userID uint) string {
+	return filepath.Join(app.DriveRoot, strconv.FormatUint(uint64(userID), 10))
+}
+
+// requireScope stops personal access tokens that were not granted scope.
+func (app *Application) requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !app.currentUser(c).allows(scope) {
+			app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access token lacks the "+scope+" scope")
+			c.Abort()
+			return
 		}
-	}()
+		c.Next()
+	}
+}
 
+// requireRole admits users holding one of roles. The role in the access
+// token is checked first, then confirmed against the database so a demoted
+// admin loses access at once rather than when the token expires.
+func (app *Application) requireRole(roles ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ip, _, err := net.SplitHostPort(c.ClientIP())
-		if err != nil {
-			ip := net.ParseIP(c.ClientIP())
-			if ip == nil {
-				app.Logger.Warn("Invalid IP address :", c.ClientIP())
-				app.ServerError(c.Writer, err)
-				return
-			}
+		user := app.currentUser(c)
+		if !slices.Contains(roles, user.Role) {
+			app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access denied")
+			c.Abort()
+			return
 		}
-		// Lock the mutex to prevent this code from being executed concurrently.
 
-		mu.Lock()
-		if _, found := clients[ip]; !found {
-			// Create and add a new client struct to the map if it doesn't already exist.
-			clients[ip] = &client{
-				limiter: rate.NewLimiter(rate.Limit(5), 3),
-			}
+		role, err := app.Model.UsersORM.UserRole(c.Request.Context(), user.ID)
+		if err != nil && !errors.Is(err, pkg.ErrUserNotFound) && !errors.Is(err, pkg.ErrAccountInActive) {
+			app.ServerError(c, err)
+			c.Abort()
+			return
 		}
 
-		clients[ip].lastSeen = time.Now()
-		if !clients[ip].limiter.Allow() {
-			mu.Unlock()
-			app.CustomError(c.Writer, http.StatusTooManyRequests, "Too, many request. Rate Limit Exceed")
+		if err != nil || !slices.Contains(roles, role) {
+			app.log(c).Warning("Stale role in token of user ", user.ID)
+			app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Access denied")
+			c.Abort()
 			return
 		}
+		c.Next()
+	}
+}
 
-		mu.Unlock()
+// requireSession keeps account management (sessions, 2FA, the tokens
+// themselves) to browser logins, so a leaked token can't be used to mint
+// more tokens or lock the owner out.
+func (app *Application) requireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.currentUser(c).SessionID == "" {
+			app.ErrorJSONResponse(c.Writer, http.StatusForbidden, "Not available to access tokens")
+			c.Abort()
+			return
+		}
+		c.Next()
 	}
 }
 
@@ -150,6 +254,9 @@ func (app *Application) TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc
 	return func(c *gin.Context) {
 		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
 		defer cancel()
+		// models read the client ip from the request context, handlers from gin's
+		ctx = context.WithValue(ctx, "ip_addr", c.ClientIP())
+		ctx = context.WithValue(ctx, "user_agent", c.Request.UserAgent())
 		c.Request = c.Request.WithContext(ctx)
 		c.Set("ip_addr", c.ClientIP())
 		c.Next()
@@ -161,18 +268,55 @@ func (app *Application) TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc
 	}
 }
 
-// just in case in future maintainance needed
-func MaintenanceMiddleware() gin.HandlerFunc {
+// maintenanceOpenPaths stay reachable in maintenance mode so admins can
+// still sign in and monitoring keeps scraping.
+var maintenanceOpenPaths = []string{"/login", "/login/2fa", "/refresh", "/logout", "/metrics"}
+
+// MaintenanceMiddleware answers 503 while SERVER_STATUS=maintenance, except
+// for signed in admins whose role is confirmed against the database.
+func (app *Application) MaintenanceMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole := c.GetHeader("X-User-Role") // only open for me
+		if os.Getenv("SERVER_STATUS") != "maintenance" {
+			c.Next()
+			return
+		}
 
-		if os.Getenv("SERVER_STATUS") == "maintenance" && userRole != "admin" {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"message": "The server is currently under maintenance. Please try again later.",
-			})
-			c.Abort()
+		path := c.Request.URL.Path
+		if slices.Contains(maintenanceOpenPaths, path) || slices.Contains(probePaths, path) ||
+			strings.HasPrefix(path, "/auth/oidc/") || app.isAdminRequest(c) {
+			c.Next()
 			return
 		}
-		c.Next()
+
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"message": "The server is currently under maintenance. Please try again later.",
+		})
+		c.Abort()
+	}
+}
+
+// isAdminRequest reports whether the request carries a live admin session.
+// Personal access tokens never bypass maintenance.
+func (app *Application) isAdminRequest(c *gin.Context) bool {
+	token := bearerToken(c)
+	if token == "" {
+		cookie, err := c.Request.Cookie(accessCookie)
+		if err != nil {
+			return false
+		}
+		token = cookie.Value
 	}
+
+	claims, err := app.parseAccessToken(token)
+	if err != nil || claims.Role != models.RoleAdmin {
+		return false
+	}
+
+	ctx := c.Request.Context()
+	if active, err := app.Model.UsersORM.SessionActive(ctx, claims.SessionID); err != nil || !active {
+		return false
+	}
+
+	role, err := app.Model.UsersORM.UserRole(ctx, claims.UserID)
+	return err == nil && role == models.RoleAdmin
 }
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/iamgak/go-drive/migrations"
+	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg/migrate"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const migrateUsage = "usage: go-drive migrate up | down [n] | status | create <name>"
+
+// migrateCommand runs `go-drive migrate up|down [n]|status` against the
+// database and returns the exit code.
+func migrateCommand(ctx context.Context, db *gorm.DB, logger *logrus.Logger, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	migrator, err := models.NewMigrator(db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrateUp(ctx, db, logger)
+		for _, mig := range applied {
+			fmt.Println("applied", mig)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("nothing to apply, schema is up to date")
+		}
+
+	case "down":
+		n := 1
+		if len(args) > 1 {
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				fmt.Fprintln(os.Stderr, "migrate: down takes the number of migrations to roll back")
+				return 2
+			}
+		}
+
+		rolledBack, err := migrator.Down(ctx, n)
+		for _, mig := range rolledBack {
+			fmt.Println("rolled back", mig)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		if len(rolledBack) == 0 {
+			fmt.Println("nothing to roll back")
+		}
+
+	case "status":
+		list, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		for _, s := range list {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			if s.Missing {
+				state += ", not in this build"
+			}
+			fmt.Printf("%-40s %s\n", s.Migration, state)
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
+
+// createMigration runs `go-drive migrate create <name>` from the source
+// tree, writing empty up and down files for every database.
+func createMigration(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	var dirs []string
+	for _, dialect := range migrations.Dialects {
+		dirs = append(dirs, filepath.Join("migrations", dialect))
+	}
+
+	paths, err := migrate.Create(dirs, args[0])
+	for _, path := range paths {
+		fmt.Println("created", path)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+// Package migrations holds the schema as numbered SQL files, one directory
+// per database, built into the binary. Add a migration with
+//
+//	go-drive migrate create <name>
+//
+// and fill in the up and down files it writes for every database.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+)
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// Dialects are the databases there are migrations for, named as gorm's
+// Dialector.Name reports them.
+var Dialects = []string{"mysql", "postgres", "sqlite"}
+
+// For returns the migrations for dialect.
+func For(dialect string) (fs.FS, error) {
+	sub, err := fs.Sub(files, dialect)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fs.Stat(files, dialect); err != nil {
+		return nil, fmt.Errorf("no migrations for database %q", dialect)
+	}
+	return sub, nil
+}
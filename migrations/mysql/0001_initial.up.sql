@@ -0,0 +1,125 @@
+-- The schema as AutoMigrate left it before versioned migrations; an
+-- existing database is adopted at this version without running it.
+
+CREATE TABLE `users` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `email` varchar(191) NOT NULL,
+    `hash_passw` longtext NOT NULL,
+    `activation_token` varchar(191),
+    `activation_expires_at` datetime(3) NULL DEFAULT null,
+    `activation_sent_at` datetime(3) NULL DEFAULT null,
+    `active` boolean DEFAULT false,
+    `role` varchar(16) NOT NULL DEFAULT 'user',
+    `quota_bytes` bigint NOT NULL DEFAULT 0,
+    `totp_secret` varchar(64),
+    `totp_enabled` boolean DEFAULT false,
+    `totp_last_counter` bigint DEFAULT 0,
+    `verified_at` datetime(3) NULL DEFAULT null,
+    `failed_logins` bigint NOT NULL DEFAULT 0,
+    `last_failed_login_at` datetime(3) NULL DEFAULT null,
+    `locked_until` datetime(3) NULL DEFAULT null,
+    `unlock_token` varchar(64),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL DEFAULT null,
+    PRIMARY KEY (`id`),
+    INDEX `idx_users_activation_token` (`activation_token`),
+    INDEX `idx_users_unlock_token` (`unlock_token`),
+    CONSTRAINT `uni_users_email` UNIQUE (`email`)
+);
+
+CREATE TABLE `users_sessions` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `family_id` varchar(64) NOT NULL,
+    `refresh_token_hash` varchar(64) NOT NULL,
+    `user_agent` varchar(512),
+    `ip_addr` varchar(191) DEFAULT null,
+    `signed_in_at` datetime(3) NOT NULL,
+    `last_seen_at` datetime(3) NULL DEFAULT null,
+    `expires_at` datetime(3) NOT NULL,
+    `rotated_at` datetime(3) NULL DEFAULT null,
+    `revoked_at` datetime(3) NULL DEFAULT null,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_users_sessions_user_id` (`user_id`),
+    INDEX `idx_users_sessions_family_id` (`family_id`),
+    UNIQUE INDEX `idx_users_sessions_refresh_token_hash` (`refresh_token_hash`)
+);
+
+CREATE TABLE `user_activity_logs` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `actor_id` bigint unsigned DEFAULT null,
+    `action` varchar(64) NOT NULL DEFAULT '',
+    `activity` longtext NOT NULL,
+    `target_path` varchar(1024),
+    `old_path` varchar(1024),
+    `size` bigint DEFAULT 0,
+    `outcome` varchar(16) NOT NULL DEFAULT 'success',
+    `details` text,
+    `superseded` boolean DEFAULT false,
+    `ip_addr` varchar(191) DEFAULT null,
+    `user_agent` varchar(512),
+    `request_id` varchar(64),
+    `prev_hash` varchar(64),
+    `user_prev_hash` varchar(64),
+    `hash` varchar(64),
+    `created_at` datetime(3) NULL,
+    `updated_at` datetime(3) NULL DEFAULT null,
+    PRIMARY KEY (`id`),
+    INDEX `idx_user_activity_logs_user_id` (`user_id`),
+    INDEX `idx_user_activity_logs_actor_id` (`actor_id`),
+    INDEX `idx_user_activity_logs_action` (`action`),
+    INDEX `idx_user_activity_logs_hash` (`hash`)
+);
+
+CREATE TABLE `password_resets` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `token_hash` varchar(64) NOT NULL,
+    `expires_at` datetime(3) NOT NULL,
+    `used_at` datetime(3) NULL DEFAULT null,
+    `ip_addr` varchar(191) DEFAULT null,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_password_resets_user_id` (`user_id`),
+    UNIQUE INDEX `idx_password_resets_token_hash` (`token_hash`)
+);
+
+CREATE TABLE `recovery_codes` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `code_hash` varchar(64) NOT NULL,
+    `used_at` datetime(3) NULL DEFAULT null,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    INDEX `idx_recovery_codes_user_id` (`user_id`)
+);
+
+CREATE TABLE `personal_access_tokens` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `name` varchar(100) NOT NULL,
+    `prefix` varchar(16) NOT NULL,
+    `token_hash` varchar(64) NOT NULL,
+    `scopes` varchar(255) NOT NULL,
+    `expires_at` datetime(3) NULL DEFAULT null,
+    `last_used_at` datetime(3) NULL DEFAULT null,
+    `revoked_at` datetime(3) NULL DEFAULT null,
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_personal_access_tokens_token_hash` (`token_hash`),
+    INDEX `idx_personal_access_tokens_user_id` (`user_id`)
+);
+
+CREATE TABLE `user_identities` (
+    `id` bigint unsigned AUTO_INCREMENT,
+    `user_id` bigint unsigned,
+    `provider` varchar(64) NOT NULL,
+    `subject` varchar(255) NOT NULL,
+    `email` varchar(255),
+    `created_at` datetime(3) NULL,
+    PRIMARY KEY (`id`),
+    UNIQUE INDEX `idx_provider_subject` (`provider`,`subject`),
+    INDEX `idx_user_identities_user_id` (`user_id`)
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `activity_chain_lock`;
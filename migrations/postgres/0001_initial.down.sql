@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS "user_identities";
+DROP TABLE IF EXISTS "personal_access_tokens";
+DROP TABLE IF EXISTS "recovery_codes";
+DROP TABLE IF EXISTS "password_resets";
+DROP TABLE IF EXISTS "user_activity_logs";
+DROP TABLE IF EXISTS "users_sessions";
+DROP TABLE IF EXISTS "users";
@@ -0,0 +1,125 @@
+-- The schema as AutoMigrate left it before versioned migrations; an
+-- existing database is adopted at this version without running it.
+
+CREATE TABLE "users" (
+    "id" bigserial,
+    "email" text NOT NULL,
+    "hash_passw" text NOT NULL,
+    "activation_token" text,
+    "activation_expires_at" timestamptz DEFAULT null,
+    "activation_sent_at" timestamptz DEFAULT null,
+    "active" boolean DEFAULT false,
+    "role" varchar(16) NOT NULL DEFAULT 'user',
+    "quota_bytes" bigint NOT NULL DEFAULT 0,
+    "totp_secret" varchar(64),
+    "totp_enabled" boolean DEFAULT false,
+    "totp_last_counter" bigint DEFAULT 0,
+    "verified_at" timestamptz DEFAULT null,
+    "failed_logins" bigint NOT NULL DEFAULT 0,
+    "last_failed_login_at" timestamptz DEFAULT null,
+    "locked_until" timestamptz DEFAULT null,
+    "unlock_token" varchar(64),
+    "created_at" timestamptz,
+    "updated_at" timestamptz DEFAULT null,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "uni_users_email" UNIQUE ("email")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_activation_token" ON "users" ("activation_token");
+CREATE INDEX IF NOT EXISTS "idx_users_unlock_token" ON "users" ("unlock_token");
+
+CREATE TABLE "users_sessions" (
+    "id" bigserial,
+    "user_id" bigint,
+    "family_id" varchar(64) NOT NULL,
+    "refresh_token_hash" varchar(64) NOT NULL,
+    "user_agent" varchar(512),
+    "ip_addr" text DEFAULT null,
+    "signed_in_at" timestamptz NOT NULL,
+    "last_seen_at" timestamptz DEFAULT null,
+    "expires_at" timestamptz NOT NULL,
+    "rotated_at" timestamptz DEFAULT null,
+    "revoked_at" timestamptz DEFAULT null,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_sessions_family_id" ON "users_sessions" ("family_id");
+CREATE INDEX IF NOT EXISTS "idx_users_sessions_user_id" ON "users_sessions" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_sessions_refresh_token_hash" ON "users_sessions" ("refresh_token_hash");
+
+CREATE TABLE "user_activity_logs" (
+    "id" bigserial,
+    "user_id" bigint,
+    "actor_id" bigint DEFAULT null,
+    "action" varchar(64) NOT NULL DEFAULT '',
+    "activity" text NOT NULL,
+    "target_path" varchar(1024),
+    "old_path" varchar(1024),
+    "size" bigint DEFAULT 0,
+    "outcome" varchar(16) NOT NULL DEFAULT 'success',
+    "details" text,
+    "superseded" boolean DEFAULT false,
+    "ip_addr" text DEFAULT null,
+    "user_agent" varchar(512),
+    "request_id" varchar(64),
+    "prev_hash" varchar(64),
+    "user_prev_hash" varchar(64),
+    "hash" varchar(64),
+    "created_at" timestamptz,
+    "updated_at" timestamptz DEFAULT null,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_user_activity_logs_action" ON "user_activity_logs" ("action");
+CREATE INDEX IF NOT EXISTS "idx_user_activity_logs_actor_id" ON "user_activity_logs" ("actor_id");
+CREATE INDEX IF NOT EXISTS "idx_user_activity_logs_hash" ON "user_activity_logs" ("hash");
+CREATE INDEX IF NOT EXISTS "idx_user_activity_logs_user_id" ON "user_activity_logs" ("user_id");
+
+CREATE TABLE "password_resets" (
+    "id" bigserial,
+    "user_id" bigint,
+    "token_hash" varchar(64) NOT NULL,
+    "expires_at" timestamptz NOT NULL,
+    "used_at" timestamptz DEFAULT null,
+    "ip_addr" text DEFAULT null,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_password_resets_user_id" ON "password_resets" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_password_resets_token_hash" ON "password_resets" ("token_hash");
+
+CREATE TABLE "recovery_codes" (
+    "id" bigserial,
+    "user_id" bigint,
+    "code_hash" varchar(64) NOT NULL,
+    "used_at" timestamptz DEFAULT null,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_recovery_codes_user_id" ON "recovery_codes" ("user_id");
+
+CREATE TABLE "personal_access_tokens" (
+    "id" bigserial,
+    "user_id" bigint,
+    "name" varchar(100) NOT NULL,
+    "prefix" varchar(16) NOT NULL,
+    "token_hash" varchar(64) NOT NULL,
+    "scopes" varchar(255) NOT NULL,
+    "expires_at" timestamptz DEFAULT null,
+    "last_used_at" timestamptz DEFAULT null,
+    "revoked_at" timestamptz DEFAULT null,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_personal_access_tokens_user_id" ON "personal_access_tokens" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_personal_access_tokens_token_hash" ON "personal_access_tokens" ("token_hash");
+
+CREATE TABLE "user_identities" (
+    "id" bigserial,
+    "user_id" bigint,
+    "provider" varchar(64) NOT NULL,
+    "subject" varchar(255) NOT NULL,
+    "email" varchar(255),
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_user_identities_user_id" ON "user_identities" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_provider_subject" ON "user_identities" ("provider","subject");
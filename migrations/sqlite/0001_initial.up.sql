@@ -0,0 +1,118 @@
+-- The schema as AutoMigrate left it before versioned migrations; an
+-- existing database is adopted at this version without running it.
+
+CREATE TABLE `users` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `email` text NOT NULL,
+    `hash_passw` text NOT NULL,
+    `activation_token` text,
+    `activation_expires_at` datetime DEFAULT null,
+    `activation_sent_at` datetime DEFAULT null,
+    `active` numeric DEFAULT false,
+    `role` text NOT NULL DEFAULT 'user',
+    `quota_bytes` integer NOT NULL DEFAULT 0,
+    `totp_secret` text,
+    `totp_enabled` numeric DEFAULT false,
+    `totp_last_counter` integer DEFAULT 0,
+    `verified_at` datetime DEFAULT null,
+    `failed_logins` integer NOT NULL DEFAULT 0,
+    `last_failed_login_at` datetime DEFAULT null,
+    `locked_until` datetime DEFAULT null,
+    `unlock_token` text,
+    `created_at` datetime,
+    `updated_at` datetime DEFAULT null,
+    CONSTRAINT `uni_users_email` UNIQUE (`email`)
+);
+CREATE INDEX `idx_users_activation_token` ON `users`(`activation_token`);
+CREATE INDEX `idx_users_unlock_token` ON `users`(`unlock_token`);
+
+CREATE TABLE `users_sessions` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `family_id` text NOT NULL,
+    `refresh_token_hash` text NOT NULL,
+    `user_agent` text,
+    `ip_addr` text DEFAULT null,
+    `signed_in_at` datetime NOT NULL,
+    `last_seen_at` datetime DEFAULT null,
+    `expires_at` datetime NOT NULL,
+    `rotated_at` datetime DEFAULT null,
+    `revoked_at` datetime DEFAULT null,
+    `created_at` datetime
+);
+CREATE INDEX `idx_users_sessions_family_id` ON `users_sessions`(`family_id`);
+CREATE INDEX `idx_users_sessions_user_id` ON `users_sessions`(`user_id`);
+CREATE UNIQUE INDEX `idx_users_sessions_refresh_token_hash` ON `users_sessions`(`refresh_token_hash`);
+
+CREATE TABLE `user_activity_logs` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `actor_id` integer DEFAULT null,
+    `action` text NOT NULL DEFAULT '',
+    `activity` text NOT NULL,
+    `target_path` text,
+    `old_path` text,
+    `size` integer DEFAULT 0,
+    `outcome` text NOT NULL DEFAULT 'success',
+    `details` text,
+    `superseded` numeric DEFAULT false,
+    `ip_addr` text DEFAULT null,
+    `user_agent` text,
+    `request_id` text,
+    `prev_hash` text,
+    `user_prev_hash` text,
+    `hash` text,
+    `created_at` datetime,
+    `updated_at` datetime DEFAULT null
+);
+CREATE INDEX `idx_user_activity_logs_action` ON `user_activity_logs`(`action`);
+CREATE INDEX `idx_user_activity_logs_actor_id` ON `user_activity_logs`(`actor_id`);
+CREATE INDEX `idx_user_activity_logs_hash` ON `user_activity_logs`(`hash`);
+CREATE INDEX `idx_user_activity_logs_user_id` ON `user_activity_logs`(`user_id`);
+
+CREATE TABLE `password_resets` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `token_hash` text NOT NULL,
+    `expires_at` datetime NOT NULL,
+    `used_at` datetime DEFAULT null,
+    `ip_addr` text DEFAULT null,
+    `created_at` datetime
+);
+CREATE INDEX `idx_password_resets_user_id` ON `password_resets`(`user_id`);
+CREATE UNIQUE INDEX `idx_password_resets_token_hash` ON `password_resets`(`token_hash`);
+
+CREATE TABLE `recovery_codes` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `code_hash` text NOT NULL,
+    `used_at` datetime DEFAULT null,
+    `created_at` datetime
+);
+CREATE INDEX `idx_recovery_codes_user_id` ON `recovery_codes`(`user_id`);
+
+CREATE TABLE `personal_access_tokens` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `name` text NOT NULL,
+    `prefix` text NOT NULL,
+    `token_hash` text NOT NULL,
+    `scopes` text NOT NULL,
+    `expires_at` datetime DEFAULT null,
+    `last_used_at` datetime DEFAULT null,
+    `revoked_at` datetime DEFAULT null,
+    `created_at` datetime
+);
+CREATE INDEX `idx_personal_access_tokens_user_id` ON `personal_access_tokens`(`user_id`);
+CREATE UNIQUE INDEX `idx_personal_access_tokens_token_hash` ON `personal_access_tokens`(`token_hash`);
+
+CREATE TABLE `user_identities` (
+    `id` integer PRIMARY KEY AUTOINCREMENT,
+    `user_id` integer,
+    `provider` text NOT NULL,
+    `subject` text NOT NULL,
+    `email` text,
+    `created_at` datetime
+);
+CREATE INDEX `idx_user_identities_user_id` ON `user_identities`(`user_id`);
+CREATE UNIQUE INDEX `idx_provider_subject` ON `user_identities`(`provider`,`subject`);
@@ -0,0 +1,7 @@
+-- The one row every activity log append locks first, so appends from all
+-- instances queue up, even while the log is empty.
+
+CREATE TABLE `activity_chain_lock` (
+    `id` integer PRIMARY KEY
+);
+INSERT INTO `activity_chain_lock` (`id`) VALUES (1);
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"gorm.io/gorm"
+)
+
+// Scopes a personal access token can be granted.
+const (
+	ScopeDriveRead   = "drive:read"
+	ScopeDriveWrite  = "drive:write"
+	ScopeShareManage = "share:manage"
+)
+
+// AccessTokenPrefix marks personal access tokens so they are easy to tell
+// from session JWTs and to spot in leaked logs or repositories.
+const AccessTokenPrefix = "gdp_"
+
+var accessTokenScopes = []string{ScopeDriveRead, ScopeDriveWrite, ScopeShareManage}
+
+// CreateAccessToken issues a named token for the user. A nil expiresAt means
+// the token never expires.
+func (m *UserModelORM) CreateAccessToken(ctx context.Context, userID uint, name string, scopes []string, expiresAt *time.Time) (*NewAccessToken, error) {
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	token := AccessTokenPrefix + raw
+
+	row := PersonalAccessToken{
+		UserID:    userID,
+		Name:      truncate(strings.TrimSpace(name), 100),
+		Prefix:    token[:len(AccessTokenPrefix)+6],
+		TokenHash: hashToken(token),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+	if err := m.db.WithContext(ctx).Create(&row).Error; err != nil {
+		return nil, err
+	}
+
+	event := Event{
+		Action:  ActionAccessTokenCreated,
+		UserID:  userID,
+		Details: map[string]any{"name": row.Name, "token_id": row.ID, "scopes": scopes},
+	}
+	if err := m.RecordEvent(ctx, event); err != nil {
+		m.log(ctx).Error("Error logging access token creation ", err)
+	}
+
+	return &NewAccessToken{AccessTokenInfo: row.info(), Token: token}, nil
+}
+
+// AccessTokens lists the user's tokens that are neither revoked nor expired.
+func (m *UserModelORM) AccessTokens(ctx context.Context, userID uint) ([]AccessTokenInfo, error) {
+	var rows []PersonalAccessToken
+	err := m.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", userID, time.Now()).
+		Order("id DESC").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]AccessTokenInfo, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, row.info())
+	}
+	return tokens, nil
+}
+
+// RevokeAccessToken revokes one of the user's own tokens.
+func (m *UserModelORM) RevokeAccessToken(ctx context.Context, userID, tokenID uint) error {
+	var row PersonalAccessToken
+	if err := m.db.WithContext(ctx).Select("id", "name").
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return pkg.ErrNoRecord
+		}
+		return err
+	}
+
+	if err := m.db.WithContext(ctx).Model(&row).Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+
+	return m.RecordEvent(ctx, Event{
+		Action:  ActionAccessTokenRevoked,
+		UserID:  userID,
+		Details: map[string]any{"name": row.Name, "token_id": row.ID},
+	})
+}
+
+// AuthenticateAccessToken resolves a raw bearer token to its owner. Unknown,
+// revoked and expired tokens and tokens of inactive users are all reported
+// as ErrInvalidToken.
+func (m *UserModelORM) AuthenticateAccessToken(ctx context.Context, token string) (*AccessTokenOwner, error) {
+	var row PersonalAccessToken
+	if err := m.db.WithContext(ctx).Where("token_hash = ?", hashToken(token)).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, pkg.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if row.RevokedAt != nil || (row.ExpiresAt != nil && now.After(*row.ExpiresAt)) {
+		return nil, pkg.ErrInvalidToken
+	}
+
+	var user User
+	if err := m.db.WithContext(ctx).Select("id", "email", "active", "role").First(&user, row.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, pkg.ErrInvalidToken
+		}
+		return nil, err
+	}
+	if !user.Active {
+		return nil, pkg.ErrInvalidToken
+	}
+
+	// like TouchSession, last use is only written once a minute
+	if row.LastUsedAt == nil || row.LastUsedAt.Before(now.Add(-time.Minute)) {
+		if err := m.db.WithContext(ctx).Model(&row).Update("last_used_at", now).Error; err != nil {
+			m.log(ctx).Error("Error updating access token last use ", err)
+		}
+	}
+
+	return &AccessTokenOwner{
+		TokenID: row.ID,
+		UserID:  user.ID,
+		Email:   user.Email,
+		Role:    user.Role,
+		Scopes:  strings.Fields(row.Scopes),
+	}, nil
+}
+
+func (t PersonalAccessToken) info() AccessTokenInfo {
+	return AccessTokenInfo{
+		ID:         t.ID,
+		Name:       t.Name,
+		Prefix:     t.Prefix,
+		Scopes:     strings.Fields(t.Scopes),
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+		CreatedAt:  t.CreatedAt,
+	}
+}
+
+// normalizeScopes rejects unknown scopes and drops duplicates.
+func normalizeScopes(scopes []string) ([]string, error) {
+	var out []string
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if !slices.Contains(accessTokenScopes, scope) {
+			return nil, pkg.ErrInvalidScope
+		}
+		if !slices.Contains(out, scope) {
+			out = append(out, scope)
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, pkg.ErrInvalidScope
+	}
+	return out, nil
+}
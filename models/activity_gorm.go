@@ -0,0 +1,55 @@
+package models
+
+import (
+	"context"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// activityExportBatch is how many rows an export reads at a time.
+const activityExportBatch = 500
+
+// ActivityLogs returns one page of activity matching filter, newest first,
+// and the number of matching rows.
+func (m *UserModelORM) ActivityLogs(ctx context.Context, filter ActivityFilter, page, perPage int) ([]UserActivityLog, int64, error) {
+	var total int64
+	if err := m.activityQuery(ctx, filter).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	logs := []UserActivityLog{}
+	err := m.activityQuery(ctx, filter).Order("id DESC").
+		Offset((page - 1) * perPage).Limit(perPage).Find(&logs).Error
+	return logs, total, err
+}
+
+// ExportActivity feeds every row matching filter to fn in batches, oldest
+// first, so large exports never sit in memory at once.
+func (m *UserModelORM) ExportActivity(ctx context.Context, filter ActivityFilter, fn func([]UserActivityLog) error) error {
+	var batch []UserActivityLog
+	return m.activityQuery(ctx, filter).FindInBatches(&batch, activityExportBatch, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
+
+func (m *UserModelORM) activityQuery(ctx context.Context, filter ActivityFilter) *gorm.DB {
+	db := m.db.WithContext(ctx).Model(&UserActivityLog{})
+	if filter.UserID != 0 {
+		db = db.Where("user_id = ?", filter.UserID)
+	}
+	if !filter.From.IsZero() {
+		db = db.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		db = db.Where("created_at < ?", filter.To)
+	}
+	if filter.Action != "" {
+		db = db.Where("action = ?", filter.Action)
+	}
+	if path := strings.TrimSpace(filter.Path); path != "" {
+		pattern := containsPattern(path)
+		db = db.Where("("+containsFold("target_path")+" OR "+containsFold("old_path")+")", pattern, pattern)
+	}
+	return db
+}
@@ -0,0 +1,220 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"gorm.io/gorm"
+)
+
+// adminUserColumns adds the newest sign in to the user row; sessions keep
+// SignedInAt across refreshes, so MAX over all rows is the last login.
+const adminUserColumns = "users.id, users.email, users.role, users.active, users.totp_enabled, users.quota_bytes, users.created_at, " +
+	"(SELECT MAX(signed_in_at) FROM users_sessions WHERE users_sessions.user_id = users.id) AS last_login_at"
+
+// ListUsers pages through users whose email contains query, newest first.
+func (m *UserModelORM) ListUsers(ctx context.Context, query string, page, perPage int) ([]AdminUser, int64, error) {
+	// a fresh chain per statement, gorm keeps Count's SELECT on a reused one
+	filtered := func() *gorm.DB {
+		db := m.db.WithContext(ctx).Model(&User{})
+		if query = strings.TrimSpace(query); query != "" {
+			db = db.Where(containsFold("email"), containsPattern(query))
+		}
+		return db
+	}
+
+	var total int64
+	if err := filtered().Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	users := []AdminUser{}
+	err := filtered().Select(adminUserColumns).Order("users.id DESC").
+		Offset((page - 1) * perPage).Limit(perPage).Scan(&users).Error
+	return users, total, err
+}
+
+// AdminUserByID returns one user as the admin API shows it.
+func (m *UserModelORM) AdminUserByID(ctx context.Context, userID uint) (*AdminUser, error) {
+	var users []AdminUser
+	err := m.db.WithContext(ctx).Model(&User{}).Select(adminUserColumns).
+		Where("users.id = ?", userID).Scan(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, pkg.ErrUserNotFound
+	}
+	return &users[0], nil
+}
+
+// SetUserActive activates or deactivates an account. Deactivating also ends
+// its sessions; personal access tokens stop working as long as it's off.
+func (m *UserModelORM) SetUserActive(ctx context.Context, adminID, userID uint, active bool) error {
+	if adminID == userID {
+		return pkg.ErrInvalidUserFound
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		updates := map[string]any{"active": active, "updated_at": time.Now()}
+		if active {
+			// an admin vouching for the account replaces the emailed link
+			updates["activation_token"] = ""
+			updates["activation_expires_at"] = nil
+		}
+
+		result := tx.Model(&User{}).Where("id = ?", userID).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return pkg.ErrUserNotFound
+		}
+
+		if active {
+			return nil
+		}
+		return tx.Model(&UsersSession{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	action := ActionAccountDeactivated
+	if active {
+		action = ActionAccountActivated
+	}
+	return m.adminEvent(ctx, adminID, userID, action, nil)
+}
+
+// ForcePasswordReset locks the current password, ends every session and
+// returns a reset link to email to the user.
+func (m *UserModelORM) ForcePasswordReset(ctx context.Context, adminID, userID uint) (*ResetToken, error) {
+	var user User
+	if err := m.db.WithContext(ctx).Select("id", "email").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, pkg.ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	// nobody knows this password, so the old one stops working right away
+	unusable, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := m.GeneratePassword(unusable[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	ip, _ := ctx.Value("ip_addr").(string)
+	now := time.Now()
+	var reset *ResetToken
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{"hash_passw": string(hashedPassword), "updated_at": now}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&UsersSession{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		reset, err = m.issuePasswordReset(tx, &user, ip, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reset, m.adminEvent(ctx, adminID, userID, ActionPasswordResetForced, nil)
+}
+
+// AdminRevokeSessions logs the user out everywhere.
+func (m *UserModelORM) AdminRevokeSessions(ctx context.Context, adminID, userID uint) error {
+	if err := m.userExists(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := m.RevokeUserSessions(ctx, userID); err != nil {
+		return err
+	}
+	return m.adminEvent(ctx, adminID, userID, ActionAllSessionsRevoked, nil)
+}
+
+// SetUserQuota changes how many bytes the user may store, zero for no limit.
+func (m *UserModelORM) SetUserQuota(ctx context.Context, adminID, userID uint, quotaBytes int64) error {
+	if quotaBytes < 0 {
+		return pkg.ErrInvalidQuota
+	}
+
+	result := m.db.WithContext(ctx).Model(&User{}).Where("id = ?", userID).Update("quota_bytes", quotaBytes)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := m.userExists(ctx, userID); err != nil {
+			return err
+		}
+	}
+
+	return m.adminEvent(ctx, adminID, userID, ActionQuotaChanged, map[string]any{"quota_bytes": quotaBytes})
+}
+
+// UserQuota returns the user's storage limit in bytes, zero for no limit.
+func (m *UserModelORM) UserQuota(ctx context.Context, userID uint) (int64, error) {
+	var user User
+	err := m.db.WithContext(ctx).Select("id", "quota_bytes").First(&user, userID).Error
+	return user.QuotaBytes, err
+}
+
+// DeleteUser removes the account and everything that logs in as it. The
+// activity log is kept so the history survives the account.
+func (m *UserModelORM) DeleteUser(ctx context.Context, adminID, userID uint) error {
+	if adminID == userID {
+		return pkg.ErrInvalidUserFound
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, model := range []any{&UsersSession{}, &PasswordReset{}, &RecoveryCode{}, &UserIdentity{}, &PersonalAccessToken{}} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		result := tx.Delete(&User{}, userID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return pkg.ErrUserNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.adminEvent(ctx, adminID, userID, ActionUserDeleted, nil)
+}
+
+func (m *UserModelORM) userExists(ctx context.Context, userID uint) error {
+	var count int64
+	if err := m.db.WithContext(ctx).Model(&User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return pkg.ErrUserNotFound
+	}
+	return nil
+}
+
+// adminEvent records an action adminID took on userID's account.
+func (m *UserModelORM) adminEvent(ctx context.Context, adminID, userID uint, action Action, details map[string]any) error {
+	return m.RecordEvent(ctx, Event{Action: action, UserID: userID, ActorID: &adminID, Details: details})
+}
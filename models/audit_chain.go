@@ -0,0 +1,206 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// chainMu makes this process append activity entries one at a time;
+// locking the activity_chain_lock row in linkActivity does the same across
+// processes.
+var chainMu sync.Mutex
+
+// activityChainLock is the single row of activity_chain_lock. Locking the
+// newest entry instead wouldn't do: a transaction that waited for it reads
+// that same entry once it is free, not the one appended meanwhile, and an
+// empty log has no row to lock at all.
+type activityChainLock struct {
+	ID uint `gorm:"primaryKey"`
+}
+
+func (activityChainLock) TableName() string {
+	return "activity_chain_lock"
+}
+
+var errChainBroken = errors.New("activity chain broken")
+
+// ChainBreak is the first entry whose link or contents don't check out.
+type ChainBreak struct {
+	EntryID uint
+	UserID  uint
+	Reason  string
+}
+
+// ActivityHead is an entry of the chain and how many entries there are up to
+// and including it.
+type ActivityHead struct {
+	EntryID uint
+	Hash    string
+	Entries int64
+}
+
+// linkActivity fills in row's links to the newest entry overall and the
+// newest entry of its user, and its own hash. It must run in the transaction
+// that creates row.
+func linkActivity(tx *gorm.DB, row *UserActivityLog) error {
+	// held until the transaction ends, so the next append anywhere waits
+	// and then sees this one's entry committed
+	var lock activityChainLock
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&lock, 1).Error; err != nil {
+		return err
+	}
+
+	var prev UserActivityLog
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "hash").
+		Order("id DESC").Limit(1).Find(&prev).Error
+	if err != nil {
+		return err
+	}
+
+	var userPrev UserActivityLog
+	err = tx.Select("id", "hash").Where("user_id = ?", row.UserID).
+		Order("id DESC").Limit(1).Find(&userPrev).Error
+	if err != nil {
+		return err
+	}
+
+	row.PrevHash, row.UserPrevHash = prev.Hash, userPrev.Hash
+	row.Hash = row.chainHash()
+	return nil
+}
+
+// chainHash is the sha256 of everything an entry records plus its links.
+// Superseded and UpdatedAt change after the fact and are left out.
+func (l *UserActivityLog) chainHash() string {
+	var actor uint
+	if l.ActorID != nil {
+		actor = *l.ActorID
+	}
+	var created string
+	if l.CreatedAt != nil {
+		created = l.CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	// a JSON array keeps the fields apart without caring what's in them
+	b, _ := json.Marshal([]any{
+		l.PrevHash, l.UserPrevHash, l.UserID, actor, l.Action, l.Activity, l.TargetPath,
+		l.OldPath, l.Size, l.Outcome, string(l.Details), l.IpAddr, l.UserAgent, l.RequestID, created,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ChainActivityLog hashes the activity rows written before the log was
+// chained, oldest first. It only runs on a log with no hashed rows yet; once
+// the chain exists an unhashed row is something VerifyActivityChain should
+// report, not something to paper over. It returns how many rows it chained.
+func ChainActivityLog(db *gorm.DB) (int, error) {
+	var hashed int64
+	if err := db.Model(&UserActivityLog{}).Where("hash <> ''").Count(&hashed).Error; err != nil {
+		return 0, err
+	}
+	if hashed > 0 {
+		return 0, nil
+	}
+
+	chained := 0
+	prev, userPrev := "", map[uint]string{}
+	update := db.Session(&gorm.Session{NewDB: true})
+	var batch []UserActivityLog
+	result := db.Model(&UserActivityLog{}).FindInBatches(&batch, activityExportBatch, func(_ *gorm.DB, _ int) error {
+		for _, row := range batch {
+			row.PrevHash, row.UserPrevHash = prev, userPrev[row.UserID]
+			row.Hash = row.chainHash()
+
+			err := update.Model(&UserActivityLog{}).Where("id = ?", row.ID).Updates(map[string]any{
+				"prev_hash":      row.PrevHash,
+				"user_prev_hash": row.UserPrevHash,
+				"hash":           row.Hash,
+			}).Error
+			if err != nil {
+				return err
+			}
+
+			prev, userPrev[row.UserID] = row.Hash, row.Hash
+			chained++
+		}
+		return nil
+	})
+	return chained, result.Error
+}
+
+// VerifyActivityChain walks the whole log oldest first, checking every link
+// and recomputing every hash. It returns how many entries it checked and the
+// first break, or nil when the chain is intact.
+func (m *UserModelORM) VerifyActivityChain(ctx context.Context) (int64, *ChainBreak, error) {
+	var checked int64
+	var broken *ChainBreak
+	prev, userPrev := "", map[uint]string{}
+
+	var batch []UserActivityLog
+	result := m.db.WithContext(ctx).Model(&UserActivityLog{}).FindInBatches(&batch, activityExportBatch, func(_ *gorm.DB, _ int) error {
+		for _, row := range batch {
+			checked++
+
+			reason := ""
+			switch {
+			case row.Hash == "":
+				reason = "entry is not hashed"
+			case row.PrevHash != prev:
+				reason = "link to the previous entry does not match, an entry before it was changed or removed"
+			case row.UserPrevHash != userPrev[row.UserID]:
+				reason = "link to the user's previous entry does not match, an entry of theirs was changed or removed"
+			case row.chainHash() != row.Hash:
+				reason = "contents do not match the hash, the entry was edited"
+			}
+			if reason != "" {
+				broken = &ChainBreak{EntryID: row.ID, UserID: row.UserID, Reason: reason}
+				return errChainBroken
+			}
+
+			prev, userPrev[row.UserID] = row.Hash, row.Hash
+		}
+		return nil
+	})
+	if result.Error != nil && !errors.Is(result.Error, errChainBroken) {
+		return checked, nil, result.Error
+	}
+	return checked, broken, nil
+}
+
+// ActivityHead returns the entry with id upTo, or the newest entry when upTo
+// is 0. A log with no entries has a zero head; a missing upTo entry is
+// pkg.ErrNoRecord.
+func (m *UserModelORM) ActivityHead(ctx context.Context, upTo uint) (ActivityHead, error) {
+	query := func() *gorm.DB {
+		db := m.db.WithContext(ctx).Model(&UserActivityLog{})
+		if upTo != 0 {
+			db = db.Where("id <= ?", upTo)
+		}
+		return db
+	}
+
+	var head ActivityHead
+	var last UserActivityLog
+	if err := query().Select("id", "hash").Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+		return head, err
+	}
+	if upTo != 0 && last.ID != upTo {
+		return head, pkg.ErrNoRecord
+	}
+
+	if err := query().Count(&head.Entries).Error; err != nil {
+		return head, err
+	}
+	head.EntryID, head.Hash = last.ID, last.Hash
+	return head, nil
+}
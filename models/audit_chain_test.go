@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// Appends through separate connection pools, as separate instances would
+// make them, must still form one chain. appendActivity is used directly so
+// chainMu doesn't serialize them.
+func TestAppendActivityConcurrentInstances(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+
+	instances := []*gorm.DB{m.db, openSecondInstance(t)}
+	const perInstance = 15
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(instances)*perInstance)
+	for i, db := range instances {
+		for n := 0; n < perInstance; n++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				row, err := newActivityRow(ctx, Event{Action: ActionLogin, UserID: uint(i*perInstance + n + 1)})
+				if err == nil {
+					err = appendActivity(db.WithContext(ctx), row)
+				}
+				errs <- err
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checked, broken, err := m.VerifyActivityChain(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if broken != nil {
+		t.Fatalf("chain forked at entry %d: %s", broken.EntryID, broken.Reason)
+	}
+	if checked != int64(len(instances)*perInstance) {
+		t.Fatalf("checked %d entries, want %d", checked, len(instances)*perInstance)
+	}
+}
+
+// openSecondInstance opens another connection pool on the test database.
+func openSecondInstance(t *testing.T) *gorm.DB {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newTestModel left the working directory in the SQLite file's dir
+	return openTestDB(t, testDialector(t, wd))
+}
+
+// chainFixture records five events, alternating between users 1 and 2, and
+// returns them oldest first.
+func chainFixture(t *testing.T, m *UserModelORM) []UserActivityLog {
+	t.Helper()
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		e := Event{Action: ActionFileUploaded, UserID: uint(2 - i%2), TargetPath: "file" + string(rune('0'+i)), Details: map[string]any{"n": i}}
+		if err := m.RecordEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rows []UserActivityLog
+	if err := m.db.Order("id").Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	checked, broken, err := m.VerifyActivityChain(ctx)
+	if err != nil || broken != nil || checked != 5 {
+		t.Fatalf("fresh chain: checked %d, break %+v, %v", checked, broken, err)
+	}
+	return rows
+}
+
+// saveRow writes every column of row back, as someone editing the table
+// would.
+func saveRow(t *testing.T, m *UserModelORM, row UserActivityLog) {
+	t.Helper()
+	if err := m.db.Save(&row).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	globalLinkBroken = "link to the previous entry"
+	userLinkBroken   = "link to the user's previous entry"
+	contentsChanged  = "contents do not match"
+)
+
+func TestVerifyActivityChainDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, m *UserModelORM, rows []UserActivityLog)
+		// the index in rows of the first entry reported and why
+		broken int
+		reason string
+	}{
+		{"details edited", func(t *testing.T, m *UserModelORM, rows []UserActivityLog) {
+			err := m.db.Model(&UserActivityLog{}).Where("id = ?", rows[2].ID).Update("details", []byte(`{"n":30}`)).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, 2, contentsChanged},
+		{"path edited and rehashed", func(t *testing.T, m *UserModelORM, rows []UserActivityLog) {
+			// the hash fits the new contents, but the next entries
+			// link to the old one
+			rows[1].TargetPath = "elsewhere"
+			rows[1].Hash = rows[1].chainHash()
+			saveRow(t, m, rows[1])
+		}, 2, globalLinkBroken},
+		{"entry deleted", func(t *testing.T, m *UserModelORM, rows []UserActivityLog) {
+			if err := m.db.Delete(&UserActivityLog{}, rows[2].ID).Error; err != nil {
+				t.Fatal(err)
+			}
+		}, 3, globalLinkBroken},
+		{"last entry deleted", func(t *testing.T, m *UserModelORM, rows []UserActivityLog) {
+			// nothing links to the newest entry, only a checkpoint
+			// catches this
+			if err := m.db.Delete(&UserActivityLog{}, rows[4].ID).Error; err != nil {
+				t.Fatal(err)
+			}
+		}, -1, ""},
+		{"entry deleted and the global links redone", func(t *testing.T, m *UserModelORM, rows []UserActivityLog) {
+			// relinking the whole log leaves the user's own chain
+			// pointing at the entry that's gone
+			if err := m.db.Delete(&UserActivityLog{}, rows[2].ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			prev := rows[1].Hash
+			for _, row := range rows[3:] {
+				row.PrevHash = prev
+				row.Hash = row.chainHash()
+				saveRow(t, m, row)
+				prev = row.Hash
+			}
+		}, 4, userLinkBroken},
+		{"entries reordered", func(t *testing.T, m *UserModelORM, rows []UserActivityLog) {
+			// swap the contents of the second and third entry
+			a, b := rows[2], rows[1]
+			a.ID, b.ID = rows[1].ID, rows[2].ID
+			saveRow(t, m, a)
+			saveRow(t, m, b)
+		}, 1, globalLinkBroken},
+		{"entry moved to another user", func(t *testing.T, m *UserModelORM, rows []UserActivityLog) {
+			rows[3].UserID = 1
+			rows[3].UserPrevHash = rows[2].Hash
+			rows[3].Hash = rows[3].chainHash()
+			saveRow(t, m, rows[3])
+		}, 4, globalLinkBroken},
+		{"hash cleared", func(t *testing.T, m *UserModelORM, rows []UserActivityLog) {
+			if err := m.db.Model(&UserActivityLog{}).Where("id = ?", rows[0].ID).Update("hash", "").Error; err != nil {
+				t.Fatal(err)
+			}
+		}, 0, "not hashed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t)
+			rows := chainFixture(t, m)
+			tt.tamper(t, m, rows)
+
+			_, broken, err := m.VerifyActivityChain(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.broken < 0 {
+				if broken != nil {
+					t.Fatalf("break %+v, want none", broken)
+				}
+				return
+			}
+			if broken == nil {
+				t.Fatal("tampering went unnoticed")
+			}
+			want := rows[tt.broken]
+			if broken.EntryID != want.ID || !strings.Contains(broken.Reason, tt.reason) {
+				t.Fatalf("break at %d (%s), want %d (%s)", broken.EntryID, broken.Reason, want.ID, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The models run on MySQL, PostgreSQL and SQLite, so queries stick to what
+// all three read the same way: booleans are bound as parameters rather than
+// written as 0 and 1, CreatedAt is filled in by GORM instead of a column
+// default each database spells differently, and LIKE goes through the
+// helpers below. Row locks (FOR UPDATE) are dropped by the SQLite dialect,
+// where the write lock taken as each transaction begins serializes the
+// activity chain instead.
+
+// likeEscape is the LIKE escape character. Not backslash: MySQL and
+// PostgreSQL disagree on how to write it in a literal, and SQLite has no
+// default escape at all.
+const likeEscape = "!"
+
+// containsFold is a case-insensitive substring match on column, taking the
+// pattern from containsPattern. MySQL's LIKE ignores case through the
+// collation, PostgreSQL's doesn't, so both sides are lowered.
+func containsFold(column string) string {
+	return "LOWER(" + column + ") LIKE ? ESCAPE '" + likeEscape + "'"
+}
+
+// containsPattern is the containsFold pattern matching s literally.
+func containsPattern(s string) string {
+	return "%" + escapeLike(strings.ToLower(s)) + "%"
+}
+
+// escapeLike makes s match literally inside a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(likeEscape, likeEscape+likeEscape, "%", likeEscape+"%", "_", likeEscape+"_").Replace(s)
+}
+
+// Timestamp is a time read from a computed column such as MAX(signed_in_at).
+// SQLite hands those back as text, without the declared column type its
+// driver goes by to parse times, so Scan parses the text itself.
+type Timestamp time.Time
+
+// timestampLayouts are the text forms SQLite drivers write times in.
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+}
+
+func (t *Timestamp) Scan(src any) error {
+	switch v := src.(type) {
+	case time.Time:
+		*t = Timestamp(v)
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	}
+	return fmt.Errorf("models: cannot scan %T into Timestamp", src)
+}
+
+func (t *Timestamp) parse(s string) error {
+	for _, layout := range timestampLayouts {
+		if v, err := time.Parse(layout, s); err == nil {
+			*t = Timestamp(v)
+			return nil
+		}
+	}
+	return fmt.Errorf("models: unknown time format %q", s)
+}
+
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return time.Time(t).MarshalJSON()
+}
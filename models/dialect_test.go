@@ -0,0 +1,326 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+)
+
+// These run the queries dialect.go is about on the test database, so set
+// TEST_DB_CONNECTION and TEST_DB_DSN to check them on MySQL or PostgreSQL.
+
+func TestListUsersSearch(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+	for _, email := range []string{"Alice@Example.com", "bob@example.com", "100%_off!@example.com", "1000off@example.com", "carol_x@example.com", "carolax@example.com"} {
+		createUser(t, m, User{Email: email, Active: true}, "password")
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"carolax@example.com", "carol_x@example.com", "1000off@example.com", "100%_off!@example.com", "bob@example.com", "Alice@Example.com"}},
+		{"ALICE", []string{"Alice@Example.com"}},
+		{"example.COM", []string{"carolax@example.com", "carol_x@example.com", "1000off@example.com", "100%_off!@example.com", "bob@example.com", "Alice@Example.com"}},
+		{"100%", []string{"100%_off!@example.com"}},
+		{"%_off", []string{"100%_off!@example.com"}},
+		{"off!", []string{"100%_off!@example.com"}},
+		{"carol_", []string{"carol_x@example.com"}},
+		{"  bob  ", []string{"bob@example.com"}},
+		{"nobody", nil},
+	}
+	for _, tt := range tests {
+		users, total, err := m.ListUsers(ctx, tt.query, 1, 50)
+		if err != nil {
+			t.Fatalf("ListUsers(%q): %v", tt.query, err)
+		}
+		var got []string
+		for _, u := range users {
+			got = append(got, u.Email)
+		}
+		if !slices.Equal(got, tt.want) || total != int64(len(tt.want)) {
+			t.Errorf("ListUsers(%q) = %q, total %d, want %q", tt.query, got, total, tt.want)
+		}
+	}
+
+	users, total, err := m.ListUsers(ctx, "example", 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 6 || len(users) != 2 || users[1].Email != "Alice@Example.com" {
+		t.Errorf("second page = %d users of %d, want the last 2 of 6", len(users), total)
+	}
+}
+
+func TestAdminUserLastLogin(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+	user := createUser(t, m, User{Email: "user@example.com", Active: true}, "password")
+	never := createUser(t, m, User{Email: "never@example.com", Active: true}, "password")
+
+	got, err := m.AdminUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastLoginAt != nil {
+		t.Fatalf("LastLoginAt = %v before any login, want nil", time.Time(*got.LastLoginAt))
+	}
+
+	latest := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	for i, signedIn := range []time.Time{latest.Add(-48 * time.Hour), latest, latest.Add(-time.Hour)} {
+		session := UsersSession{
+			UserID:           user.ID,
+			FamilyID:         "family",
+			RefreshTokenHash: hashToken(string(rune('a' + i))),
+			SignedInAt:       signedIn,
+			ExpiresAt:        signedIn.Add(time.Hour),
+		}
+		if err := m.db.Create(&session).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err = m.AdminUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastLoginAt == nil || !time.Time(*got.LastLoginAt).Equal(latest) {
+		t.Fatalf("LastLoginAt = %v, want %v", got.LastLoginAt, latest)
+	}
+
+	users, _, err := m.ListUsers(ctx, "", 1, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range users {
+		switch u.ID {
+		case user.ID:
+			if u.LastLoginAt == nil || !time.Time(*u.LastLoginAt).Equal(latest) {
+				t.Errorf("listed LastLoginAt = %v, want %v", u.LastLoginAt, latest)
+			}
+		case never.ID:
+			if u.LastLoginAt != nil {
+				t.Errorf("listed LastLoginAt = %v for a user who never logged in", time.Time(*u.LastLoginAt))
+			}
+		}
+	}
+
+	if _, err := m.AdminUserByID(ctx, never.ID+100); !errors.Is(err, pkg.ErrUserNotFound) {
+		t.Fatalf("AdminUserByID(unknown) = %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestTimestampScan(t *testing.T) {
+	want := time.Date(2026, 3, 4, 5, 6, 7, 890000000, time.UTC)
+	for _, src := range []any{
+		want,
+		"2026-03-04 05:06:07.89 +0000 UTC",
+		"2026-03-04 05:06:07.89+00:00",
+		"2026-03-04T05:06:07.89Z",
+		[]byte("2026-03-04 05:06:07.89"),
+	} {
+		var ts Timestamp
+		if err := ts.Scan(src); err != nil {
+			t.Errorf("Scan(%v): %v", src, err)
+			continue
+		}
+		if !time.Time(ts).Equal(want) {
+			t.Errorf("Scan(%v) = %v, want %v", src, time.Time(ts), want)
+		}
+	}
+
+	var ts Timestamp
+	if err := ts.Scan("yesterday"); err == nil {
+		t.Error("Scan accepted an unknown format")
+	}
+	if err := ts.Scan(int64(0)); err == nil {
+		t.Error("Scan accepted an integer")
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	m := newTestModel(t)
+	m.loginDelayAfter = 100 // no delays between the attempts below
+	m.lockoutThreshold = 3
+	ctx := context.Background()
+	user := createUser(t, m, User{Email: "user@example.com", Active: true}, "password")
+
+	wrong := &UserStruct{Email: user.Email, Password: "wrong"}
+	for i := 1; i < m.lockoutThreshold; i++ {
+		if _, err := m.LoginUser(ctx, wrong); !errors.Is(err, pkg.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: %v, want ErrInvalidCredentials", i, err)
+		}
+	}
+	_, err := m.LoginUser(ctx, wrong)
+	var blocked *LoginBlockedError
+	if !errors.As(err, &blocked) || !blocked.Locked || blocked.Notice == nil {
+		t.Fatalf("locking attempt: %v, want a lockout with a notice", err)
+	}
+
+	// the right password is refused while locked_until is in the future
+	right := &UserStruct{Email: user.Email, Password: "password"}
+	if _, err := m.LoginUser(ctx, right); !errors.Is(err, pkg.ErrAccountLocked) {
+		t.Fatalf("login while locked: %v, want ErrAccountLocked", err)
+	}
+
+	// and let through once it has passed
+	past := time.Now().Add(-time.Minute)
+	if err := m.db.Model(&User{}).Where("id = ?", user.ID).Update("locked_until", past).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.LoginUser(ctx, right); err != nil {
+		t.Fatalf("login after the lock ran out: %v", err)
+	}
+	if got := reloadUser(t, m, user.ID); got.LockedUntil != nil || got.FailedLogins != 0 {
+		t.Fatalf("login left locked_until %v and %d failures", got.LockedUntil, got.FailedLogins)
+	}
+
+	// the emailed token lifts a lock early
+	for i := 0; i < m.lockoutThreshold; i++ {
+		_, err = m.LoginUser(ctx, wrong)
+	}
+	if !errors.As(err, &blocked) || blocked.Notice == nil {
+		t.Fatalf("second locking attempt: %v, want a lockout with a notice", err)
+	}
+	if err := m.UnlockAccount(ctx, blocked.Notice.UnlockToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.LoginUser(ctx, right); err != nil {
+		t.Fatalf("login after unlocking: %v", err)
+	}
+	if err := m.UnlockAccount(ctx, blocked.Notice.UnlockToken); !errors.Is(err, pkg.ErrInvalidToken) {
+		t.Fatalf("reused unlock token: %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	m := newTestModel(t)
+	m.loginDelayAfter = 1
+	ctx := context.Background()
+	user := createUser(t, m, User{Email: "user@example.com", Active: true}, "password")
+
+	if _, err := m.LoginUser(ctx, &UserStruct{Email: user.Email, Password: "wrong"}); !errors.Is(err, pkg.ErrInvalidCredentials) {
+		t.Fatalf("first attempt: %v, want ErrInvalidCredentials", err)
+	}
+	_, err := m.LoginUser(ctx, &UserStruct{Email: user.Email, Password: "password"})
+	if !errors.Is(err, pkg.ErrTooManyRequests) {
+		t.Fatalf("attempt within the delay: %v, want ErrTooManyRequests", err)
+	}
+
+	earlier := time.Now().Add(-time.Minute)
+	if err := m.db.Model(&User{}).Where("id = ?", user.ID).Update("last_failed_login_at", earlier).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.LoginUser(ctx, &UserStruct{Email: user.Email, Password: "password"}); err != nil {
+		t.Fatalf("attempt after the delay: %v", err)
+	}
+}
+
+func TestPasswordResetExpiry(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+	user := createUser(t, m, User{Email: "user@example.com", Active: true}, "password")
+
+	reset, err := m.RequestPasswordReset(ctx, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RequestPasswordReset(ctx, user.Email); !errors.Is(err, pkg.ErrTooManyRequests) {
+		t.Fatalf("second request right away: %v, want ErrTooManyRequests", err)
+	}
+	if ok, err := m.ResetTokenValid(ctx, reset.Token); err != nil || !ok {
+		t.Fatalf("ResetTokenValid(fresh) = %v, %v, want true", ok, err)
+	}
+
+	// move the link into the past: it's stale, and a new one may be asked for
+	earlier := time.Now().Add(-m.resetTTL - time.Minute)
+	err = m.db.Model(&PasswordReset{}).Where("user_id = ?", user.ID).
+		Updates(map[string]any{"created_at": earlier, "expires_at": earlier.Add(m.resetTTL)}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.ResetTokenValid(ctx, reset.Token); err != nil || ok {
+		t.Fatalf("ResetTokenValid(expired) = %v, %v, want false", ok, err)
+	}
+	if err := m.ResetPassword(ctx, reset.Token, "new password"); !errors.Is(err, pkg.ErrInvalidToken) {
+		t.Fatalf("ResetPassword(expired) = %v, want ErrInvalidToken", err)
+	}
+
+	reset, err = m.RequestPasswordReset(ctx, user.Email)
+	if err != nil {
+		t.Fatalf("request after the interval: %v", err)
+	}
+	if err := m.ResetPassword(ctx, reset.Token, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := m.ResetTokenValid(ctx, reset.Token); err != nil || ok {
+		t.Fatalf("ResetTokenValid(used) = %v, %v, want false", ok, err)
+	}
+}
+
+func TestActivityLogsFilter(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+
+	day := time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC)
+	rows := []struct {
+		userID  uint
+		action  Action
+		path    string
+		oldPath string
+		at      time.Time
+	}{
+		{1, ActionFileUploaded, "/Docs/Report_2026.pdf", "", day.Add(-time.Hour)},
+		{1, ActionFileRenamed, "/docs/final.pdf", "/docs/100%_draft.pdf", day},
+		{1, ActionFileDeleted, "/docs/report2026.pdf", "", day.Add(12 * time.Hour)},
+		{2, ActionFileUploaded, "/photos/cat.jpg", "", day.Add(24 * time.Hour)},
+		{2, ActionLogin, "", "", day.Add(25 * time.Hour)},
+	}
+	for _, r := range rows {
+		row, err := newActivityRow(ctx, Event{Action: r.action, UserID: r.userID, TargetPath: r.path, OldPath: r.oldPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		row.CreatedAt = &r.at
+		if err := appendActivity(m.db, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter ActivityFilter
+		want   []Action
+	}{
+		{"all", ActivityFilter{}, []Action{ActionLogin, ActionFileUploaded, ActionFileDeleted, ActionFileRenamed, ActionFileUploaded}},
+		{"user", ActivityFilter{UserID: 2}, []Action{ActionLogin, ActionFileUploaded}},
+		{"from is inclusive", ActivityFilter{From: day}, []Action{ActionLogin, ActionFileUploaded, ActionFileDeleted, ActionFileRenamed}},
+		{"to is exclusive", ActivityFilter{To: day.Add(24 * time.Hour)}, []Action{ActionFileDeleted, ActionFileRenamed, ActionFileUploaded}},
+		{"one day", ActivityFilter{From: day, To: day.Add(24 * time.Hour)}, []Action{ActionFileDeleted, ActionFileRenamed}},
+		{"action", ActivityFilter{Action: ActionFileUploaded}, []Action{ActionFileUploaded, ActionFileUploaded}},
+		{"path ignores case", ActivityFilter{Path: "REPORT"}, []Action{ActionFileDeleted, ActionFileUploaded}},
+		{"path matches the old path", ActivityFilter{Path: "draft"}, []Action{ActionFileRenamed}},
+		{"path % is literal", ActivityFilter{Path: "100%"}, []Action{ActionFileRenamed}},
+		{"path _ is literal", ActivityFilter{Path: "report_"}, []Action{ActionFileUploaded}},
+		{"combined", ActivityFilter{UserID: 1, From: day, Action: ActionFileDeleted, Path: "/docs/"}, []Action{ActionFileDeleted}},
+		{"nothing", ActivityFilter{Path: "/music"}, nil},
+	}
+	for _, tt := range tests {
+		logs, total, err := m.ActivityLogs(ctx, tt.filter, 1, 50)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		var got []Action
+		for _, l := range logs {
+			got = append(got, l.Action)
+		}
+		if !slices.Equal(got, tt.want) || total != int64(len(tt.want)) {
+			t.Errorf("%s: got %v, total %d, want %v", tt.name, got, total, tt.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Action is the typed kind of an activity event.
+type Action string
+
+const (
+	ActionUserRegistered       Action = "user.registered"
+	ActionAccountActivated     Action = "account.activated"
+	ActionAccountDeactivated   Action = "account.deactivated"
+	ActionUserDeleted          Action = "user.deleted"
+	ActionRoleChanged          Action = "user.role_changed"
+	ActionQuotaChanged         Action = "user.quota_changed"
+	ActionLogin                Action = "auth.login"
+	ActionLoginFailed          Action = "auth.login_failed"
+	ActionAccountLocked        Action = "account.locked"
+	ActionAccountUnlocked      Action = "account.unlocked"
+	ActionLogout               Action = "auth.logout"
+	ActionRefreshReused        Action = "auth.refresh_reused"
+	ActionIdentityLinked       Action = "auth.identity_linked"
+	ActionSessionRevoked       Action = "session.revoked"
+	ActionOtherSessionsRevoked Action = "session.others_revoked"
+	ActionAllSessionsRevoked   Action = "session.all_revoked"
+	ActionPasswordResetRequest Action = "password.reset_requested"
+	ActionPasswordReset        Action = "password.reset_completed"
+	ActionPasswordResetForced  Action = "password.reset_forced"
+	ActionTwoFactorEnabled     Action = "2fa.enabled"
+	ActionTwoFactorDisabled    Action = "2fa.disabled"
+	ActionTwoFactorFailed      Action = "2fa.failed"
+	ActionAccessTokenCreated   Action = "token.created"
+	ActionAccessTokenRevoked   Action = "token.revoked"
+	ActionFolderCreated        Action = "folder.created"
+	ActionFileUploaded         Action = "file.uploaded"
+	ActionFileDeleted          Action = "file.deleted"
+	ActionFileRenamed          Action = "file.renamed"
+
+	// ActionLegacy marks old free-form rows the migration couldn't parse.
+	ActionLegacy Action = "legacy"
+)
+
+// Outcomes of an event.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// actionLabels are the readable names used for the Activity summary. They
+// are also the prefixes the old free-form rows were written with, which is
+// how MigrateActivityEvents recognises them.
+var actionLabels = map[Action]string{
+	ActionUserRegistered:       "New User Register",
+	ActionAccountActivated:     "Account Activated",
+	ActionAccountDeactivated:   "Account Deactivated",
+	ActionUserDeleted:          "User Deleted",
+	ActionRoleChanged:          "Role Changed",
+	ActionQuotaChanged:         "Quota Changed",
+	ActionLogin:                "Logged In",
+	ActionLoginFailed:          "Login Failed",
+	ActionAccountLocked:        "Account Locked",
+	ActionAccountUnlocked:      "Account Unlocked",
+	ActionLogout:               "Logged Out",
+	ActionRefreshReused:        "Refresh Token Reuse Detected",
+	ActionIdentityLinked:       "Identity Linked",
+	ActionSessionRevoked:       "Session Revoked",
+	ActionOtherSessionsRevoked: "Other Sessions Revoked",
+	ActionAllSessionsRevoked:   "All Sessions Revoked",
+	ActionPasswordResetRequest: "Password Reset Requested",
+	ActionPasswordReset:        "Password Reset Completed",
+	ActionPasswordResetForced:  "Password Reset Forced",
+	ActionTwoFactorEnabled:     "Two Factor Enabled",
+	ActionTwoFactorDisabled:    "Two Factor Disabled",
+	ActionTwoFactorFailed:      "Two Factor Failed",
+	ActionAccessTokenCreated:   "Access Token Created",
+	ActionAccessTokenRevoked:   "Access Token Revoked",
+	ActionFolderCreated:        "Folder Created",
+	ActionFileUploaded:         "File Uploaded",
+	ActionFileDeleted:          "File Deleted",
+	ActionFileRenamed:          "File Renamed",
+	ActionLegacy:               "Activity",
+}
+
+// Valid reports whether a is a known action.
+func (a Action) Valid() bool {
+	_, ok := actionLabels[a]
+	return ok
+}
+
+// Event is what handlers and model methods record. The IP address, user
+// agent and request id come from the request context.
+type Event struct {
+	Action     Action
+	UserID     uint
+	ActorID    *uint
+	TargetPath string
+	OldPath    string
+	Size       int64
+	Outcome    string // OutcomeSuccess when empty
+	Details    map[string]any
+}
+
+// RecordEvent writes e to the activity log, chained to the entries before
+// it. The previous event of the same action on the same target is marked
+// superseded.
+func (m *UserModelORM) RecordEvent(ctx context.Context, e Event) error {
+	row, err := newActivityRow(ctx, e)
+	if err != nil {
+		return err
+	}
+
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	return appendActivity(m.db.WithContext(ctx), row)
+}
+
+// appendActivity supersedes the previous event on row's target and adds row
+// to the chain, in one transaction.
+func appendActivity(db *gorm.DB, row *UserActivityLog) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UserActivityLog{}).
+			Where("user_id = ? AND action = ? AND target_path = ? AND superseded = ?", row.UserID, row.Action, row.TargetPath, false).
+			Updates(map[string]any{"superseded": true, "updated_at": time.Now()}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := linkActivity(tx, row); err != nil {
+			return err
+		}
+		return tx.Create(row).Error
+	})
+}
+
+func newActivityRow(ctx context.Context, e Event) (*UserActivityLog, error) {
+	if !e.Action.Valid() {
+		return nil, fmt.Errorf("unknown activity action %q", e.Action)
+	}
+
+	outcome := e.Outcome
+	if outcome == "" {
+		outcome = OutcomeSuccess
+	}
+
+	var details json.RawMessage
+	if len(e.Details) > 0 {
+		var err error
+		if details, err = json.Marshal(e.Details); err != nil {
+			return nil, err
+		}
+	}
+
+	ip, _ := ctx.Value("ip_addr").(string)
+	userAgent, _ := ctx.Value("user_agent").(string)
+	requestID, _ := ctx.Value("request_id").(string)
+	// whole seconds in UTC, so the time read back hashes the same
+	now := time.Now().UTC().Truncate(time.Second)
+
+	return &UserActivityLog{
+		UserID:     e.UserID,
+		ActorID:    e.ActorID,
+		Action:     e.Action,
+		Activity:   summarize(e),
+		TargetPath: e.TargetPath,
+		OldPath:    e.OldPath,
+		Size:       e.Size,
+		Outcome:    outcome,
+		Details:    details,
+		IpAddr:     ip,
+		UserAgent:  truncate(userAgent, 512),
+		RequestID:  requestID,
+		CreatedAt:  &now,
+	}, nil
+}
+
+// summarize renders the readable Activity line, e.g. "File Renamed: a to b".
+func summarize(e Event) string {
+	label := actionLabels[e.Action]
+	switch {
+	case e.OldPath != "":
+		return fmt.Sprintf("%s: %s to %s", label, e.OldPath, e.TargetPath)
+	case e.TargetPath != "":
+		return label + ": " + e.TargetPath
+	}
+
+	for _, key := range []string{"name", "provider", "role"} {
+		if v, ok := e.Details[key]; ok {
+			return fmt.Sprintf("%s: %v", label, v)
+		}
+	}
+	return label
+}
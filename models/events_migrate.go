@@ -0,0 +1,119 @@
+package models
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// legacyDetailKeys says what the text after the colon meant for old rows of
+// actions that didn't carry a path.
+var legacyDetailKeys = map[Action]string{
+	ActionUserRegistered:     "provider",
+	ActionIdentityLinked:     "provider",
+	ActionAccessTokenCreated: "name",
+	ActionAccessTokenRevoked: "name",
+	ActionRoleChanged:        "role",
+}
+
+var legacyFailures = map[Action]bool{
+	ActionRefreshReused:   true,
+	ActionTwoFactorFailed: true,
+}
+
+// MigrateActivityEvents turns rows written as free-form strings, before
+// events were typed, into typed events. Rows it can't parse keep their text
+// and get ActionLegacy. It only touches rows without an action, so running it
+// again is a no-op, and returns how many rows it converted.
+func MigrateActivityEvents(db *gorm.DB) (int, error) {
+	converted := 0
+	// updates go through a fresh session, the batch tx still carries the
+	// query's conditions
+	update := db.Session(&gorm.Session{NewDB: true})
+	var batch []UserActivityLog
+	result := db.Model(&UserActivityLog{}).Where("action = '' OR action IS NULL").
+		FindInBatches(&batch, activityExportBatch, func(_ *gorm.DB, _ int) error {
+			for _, row := range batch {
+				e := parseLegacyActivity(row.Activity, row.UserID)
+
+				var details json.RawMessage
+				if len(e.Details) > 0 {
+					details, _ = json.Marshal(e.Details)
+				}
+
+				outcome := OutcomeSuccess
+				if legacyFailures[e.Action] {
+					outcome = OutcomeFailure
+				}
+
+				err := update.Model(&UserActivityLog{}).Where("id = ?", row.ID).Updates(map[string]any{
+					"action":      e.Action,
+					"target_path": e.TargetPath,
+					"old_path":    e.OldPath,
+					"outcome":     outcome,
+					"details":     details,
+				}).Error
+				if err != nil {
+					return err
+				}
+				converted++
+			}
+			return nil
+		})
+	return converted, result.Error
+}
+
+// parseLegacyActivity reads strings like "File Renamed: a to b " back into
+// an event of user userID. A rename whose paths themselves contain " to " is
+// split at the first one; the original text stays in Activity either way.
+func parseLegacyActivity(activity string, userID uint) Event {
+	label, rest, _ := strings.Cut(activity, ":")
+	label, rest = strings.TrimSpace(label), strings.TrimSpace(rest)
+
+	for action, l := range actionLabels {
+		if l != label || action == ActionLegacy {
+			continue
+		}
+
+		e := Event{Action: action}
+		switch {
+		case rest == "":
+		case action == ActionFileRenamed:
+			old, target, ok := strings.Cut(rest, " to ")
+			if !ok {
+				old, target = "", rest
+			}
+			e.OldPath, e.TargetPath = legacyRelPath(strings.TrimSpace(old), userID), legacyRelPath(strings.TrimSpace(target), userID)
+		case legacyDetailKeys[action] != "":
+			e.Details = map[string]any{legacyDetailKeys[action]: rest}
+		default:
+			e.TargetPath = legacyRelPath(rest, userID)
+		}
+		return e
+	}
+
+	return Event{Action: ActionLegacy, Details: map[string]any{"text": activity}}
+}
+
+// legacyRelPath makes a path some old handlers logged on the server, their
+// base dir <drive root>/<user id>/ joined with the user's path, relative to
+// the user's drive like every other path. The root was hard-coded, ending in
+// drive/ as shipped, so the base dir ends at the user's id: the one after a
+// drive directory if there is one, else the first.
+func legacyRelPath(path string, userID uint) string {
+	if !strings.HasPrefix(path, "/") {
+		return path
+	}
+
+	id := "/" + strconv.FormatUint(uint64(userID), 10) + "/"
+	padded := path + "/"
+	i := strings.Index(padded, "/drive"+id)
+	if i >= 0 {
+		i += len("/drive")
+	} else if i = strings.Index(padded, id); i < 0 {
+		return path
+	}
+	return strings.Trim(padded[i+len(id):], "/")
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLegacyActivity(t *testing.T) {
+	tests := []struct {
+		activity string
+		want     Event
+	}{
+		// as the old handlers wrote them for user 1, trailing space included
+		{"New User Register", Event{Action: ActionUserRegistered}},
+		{"Logged In", Event{Action: ActionLogin}},
+		{"Account Activated", Event{Action: ActionAccountActivated}},
+		{"Folder Created: docs/2024 ", Event{Action: ActionFolderCreated, TargetPath: "docs/2024"}},
+		{"File Deleted: docs/a.txt ", Event{Action: ActionFileDeleted, TargetPath: "docs/a.txt"}},
+		{"File Uploaded: photo.jpg", Event{Action: ActionFileUploaded, TargetPath: "photo.jpg"}},
+		{"File Renamed: docs/a.txt to docs/b.txt ", Event{Action: ActionFileRenamed, OldPath: "docs/a.txt", TargetPath: "docs/b.txt"}},
+
+		// renames split at the first " to ", and only when there is one
+		{"File Renamed: how to a.txt to b.txt", Event{Action: ActionFileRenamed, OldPath: "how", TargetPath: "a.txt to b.txt"}},
+		{"File Renamed: docs/b.txt", Event{Action: ActionFileRenamed, TargetPath: "docs/b.txt"}},
+		{"File Renamed: tomato.txt", Event{Action: ActionFileRenamed, TargetPath: "tomato.txt"}},
+		{"File Renamed:", Event{Action: ActionFileRenamed}},
+
+		// deletes were logged with the server path of the user's base dir,
+		// which is cut off
+		{"File Deleted: /home/iamgak/Desktop/info/assignment/go-task-github/drive/1/new/taylor_swift.jpg ", Event{Action: ActionFileDeleted, TargetPath: "new/taylor_swift.jpg"}},
+		{"File Deleted: /home/iamgak/drive/1/", Event{Action: ActionFileDeleted}},
+		{"File Deleted: /home/1/drive/1/docs/1/a.txt", Event{Action: ActionFileDeleted, TargetPath: "docs/1/a.txt"}},
+		{"File Deleted: /srv/files/1/docs/a.txt", Event{Action: ActionFileDeleted, TargetPath: "docs/a.txt"}},
+		{"File Renamed: /srv/drive/1/a.txt to /srv/drive/1/b.txt", Event{Action: ActionFileRenamed, OldPath: "a.txt", TargetPath: "b.txt"}},
+		// another user's base dir, or none, is left alone
+		{"File Deleted: /srv/drive/12/a.txt", Event{Action: ActionFileDeleted, TargetPath: "/srv/drive/12/a.txt"}},
+		{"File Deleted: docs/1/a.txt", Event{Action: ActionFileDeleted, TargetPath: "docs/1/a.txt"}},
+
+		// only the first colon separates the label
+		{"File Uploaded: notes: draft.txt", Event{Action: ActionFileUploaded, TargetPath: "notes: draft.txt"}},
+
+		// actions whose text was a detail rather than a path
+		{"New User Register: google", Event{Action: ActionUserRegistered, Details: map[string]any{"provider": "google"}}},
+		{"Access Token Created: ci", Event{Action: ActionAccessTokenCreated, Details: map[string]any{"name": "ci"}}},
+		{"Role Changed: admin", Event{Action: ActionRoleChanged, Details: map[string]any{"role": "admin"}}},
+
+		// anything else is kept as text
+		{"Something Else: x", Event{Action: ActionLegacy, Details: map[string]any{"text": "Something Else: x"}}},
+		{"", Event{Action: ActionLegacy, Details: map[string]any{"text": ""}}},
+		{"Activity: x", Event{Action: ActionLegacy, Details: map[string]any{"text": "Activity: x"}}},
+	}
+
+	for _, tt := range tests {
+		if got := parseLegacyActivity(tt.activity, 1); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLegacyActivity(%q, 1) = %+v, want %+v", tt.activity, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"gorm.io/gorm"
+)
+
+// ExternalIdentity is what an OpenID Connect provider asserted about the user.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// LoginWithIdentity signs in the user linked to identity. The first login
+// links an existing account with the same, provider verified, email or
+// provisions a new account. It stops where a password login would: at a
+// locked account, and at the second factor when 2FA is on, so the provider
+// only stands in for the password.
+func (m *UserModelORM) LoginWithIdentity(ctx context.Context, identity ExternalIdentity) (*LoginResult, error) {
+	if identity.Subject == "" {
+		return nil, pkg.ErrInvalidToken
+	}
+
+	var user User
+	var link UserIdentity
+	err := m.db.WithContext(ctx).Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+	switch {
+	case err == nil:
+		if err := m.db.WithContext(ctx).First(&user, link.UserID).Error; err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if user, err = m.linkIdentity(ctx, identity); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	if !user.Active {
+		return nil, pkg.ErrAccountInActive
+	}
+
+	// the delay between attempts is about guessed passwords, a lock stands
+	// whatever the way in
+	if blocked := m.loginBlocked(&user, time.Now()); blocked != nil && blocked.Locked {
+		m.recordLoginFailure(ctx, user.ID, "locked", map[string]any{"provider": identity.Provider})
+		return nil, blocked
+	}
+
+	if user.TOTPEnabled {
+		mfaToken, err := m.generateMFAToken(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAToken: mfaToken}, nil
+	}
+
+	tokens, err := m.startSession(ctx, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{Tokens: tokens}, nil
+}
+
+// linkIdentity attaches identity to the account owning its email, creating
+// that account when there is none. Only verified emails are trusted, or any
+// provider account could claim someone else's drive.
+func (m *UserModelORM) linkIdentity(ctx context.Context, identity ExternalIdentity) (User, error) {
+	var user User
+	email := strings.TrimSpace(identity.Email)
+	if email == "" || !identity.EmailVerified {
+		return user, pkg.ErrEmailNotVerified
+	}
+
+	event := Event{Details: map[string]any{"provider": identity.Provider}}
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("email = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			// never activated yet: the provider just proved the address is
+			// theirs. Accounts that were active and switched off stay off.
+			if !user.Active && user.VerifiedAt.IsZero() {
+				if err := m.claimUnverified(tx, &user); err != nil {
+					return err
+				}
+			}
+			event.Action = ActionIdentityLinked
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			// SSO users have no usable password until they reset one
+			hashedPassword, err := m.unusablePassword()
+			if err != nil {
+				return err
+			}
+
+			user = User{Email: email, HashPassw: string(hashedPassword), Active: true, VerifiedAt: time.Now()}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			event.Action = ActionUserRegistered
+		default:
+			return err
+		}
+
+		return tx.Create(&UserIdentity{
+			UserID:   user.ID,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    email,
+		}).Error
+	})
+	if err != nil {
+		return user, err
+	}
+
+	event.UserID = user.ID
+	return user, m.RecordEvent(ctx, event)
+}
+
+// claimUnverified hands a registered but never activated account to the
+// identity that proved its email. Whoever registered it may not have owned
+// the address, so nothing they set up survives: the password becomes
+// unusable, 2FA and its recovery codes go, and any session or token ends.
+func (m *UserModelORM) claimUnverified(tx *gorm.DB, user *User) error {
+	hashedPassword, err := m.unusablePassword()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = tx.Model(user).Updates(map[string]any{
+		"active":            true,
+		"verified_at":       now,
+		"activation_token":  nil,
+		"hash_passw":        string(hashedPassword),
+		"totp_secret":       "",
+		"totp_enabled":      false,
+		"totp_last_counter": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&UsersSession{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&PersonalAccessToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+
+	user.Active = true
+	user.TOTPEnabled = false
+	return nil
+}
+
+// unusablePassword is the hash of a random password nobody knows, for
+// accounts that sign in through a provider until they reset it.
+func (m *UserModelORM) unusablePassword() ([]byte, error) {
+	random, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+	return m.GeneratePassword(random[:32])
+}
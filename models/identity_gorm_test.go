@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginWithIdentityClaimsUnverifiedAccount(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+
+	// registered by someone who never proved the address
+	user := createUser(t, m, User{Email: "victim@example.com", TOTPSecret: "JBSWY3DPEHPK3PXP", TOTPEnabled: true}, "attacker-password")
+	now := time.Now()
+	m.db.Create(&UsersSession{UserID: user.ID, FamilyID: "f1", RefreshTokenHash: "h1", SignedInAt: now, ExpiresAt: now.Add(time.Hour)})
+	m.db.Create(&PersonalAccessToken{UserID: user.ID, Name: "ci", Prefix: "gdp_x", TokenHash: "t1", Scopes: ScopeDriveRead})
+	m.db.Create(&RecoveryCode{UserID: user.ID, CodeHash: "c1"})
+
+	result, err := m.LoginWithIdentity(ctx, ExternalIdentity{Provider: "google", Subject: "sub-1", Email: user.Email, EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tokens == nil || result.MFAToken != "" {
+		t.Fatalf("want a finished login, got %+v", result)
+	}
+
+	got := reloadUser(t, m, user.ID)
+	if !got.Active || got.VerifiedAt.IsZero() {
+		t.Error("account not activated")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(got.HashPassw), []byte("attacker-password")) == nil {
+		t.Error("the registering password still works")
+	}
+	if got.TOTPEnabled || got.TOTPSecret != "" {
+		t.Error("the registering 2FA survived")
+	}
+
+	var live int64
+	m.db.Model(&UsersSession{}).Where("family_id = ? AND revoked_at IS NULL", "f1").Count(&live)
+	if live != 0 {
+		t.Error("session from before the link still live")
+	}
+	m.db.Model(&PersonalAccessToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&live)
+	if live != 0 {
+		t.Error("access token from before the link still live")
+	}
+	m.db.Model(&RecoveryCode{}).Where("user_id = ?", user.ID).Count(&live)
+	if live != 0 {
+		t.Error("recovery codes from before the link survived")
+	}
+}
+
+func TestLoginWithIdentityKeepsVerifiedPassword(t *testing.T) {
+	m := newTestModel(t)
+
+	user := createUser(t, m, User{Email: "owner@example.com", Active: true, VerifiedAt: time.Now()}, "owner-password")
+	if _, err := m.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google", Subject: "sub-1", Email: user.Email, EmailVerified: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := reloadUser(t, m, user.ID)
+	if bcrypt.CompareHashAndPassword([]byte(got.HashPassw), []byte("owner-password")) != nil {
+		t.Error("linking changed a verified account's password")
+	}
+}
+
+func TestLoginWithIdentityAsksForSecondFactor(t *testing.T) {
+	m := newTestModel(t)
+
+	user := createUser(t, m, User{Email: "mfa@example.com", Active: true, VerifiedAt: time.Now(), TOTPSecret: "JBSWY3DPEHPK3PXP", TOTPEnabled: true}, "password")
+	m.db.Create(&UserIdentity{UserID: user.ID, Provider: "google", Subject: "sub-1", Email: user.Email})
+
+	result, err := m.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google", Subject: "sub-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Tokens != nil || result.MFAToken == "" {
+		t.Fatalf("want an mfa token and no session, got %+v", result)
+	}
+
+	var sessions int64
+	m.db.Model(&UsersSession{}).Where("user_id = ?", user.ID).Count(&sessions)
+	if sessions != 0 {
+		t.Errorf("%d sessions started before the second factor", sessions)
+	}
+}
+
+func TestLoginWithIdentityRespectsLockout(t *testing.T) {
+	m := newTestModel(t)
+
+	until := time.Now().Add(10 * time.Minute)
+	user := createUser(t, m, User{Email: "locked@example.com", Active: true, VerifiedAt: time.Now(), LockedUntil: &until}, "password")
+	m.db.Create(&UserIdentity{UserID: user.ID, Provider: "google", Subject: "sub-1", Email: user.Email})
+
+	_, err := m.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google", Subject: "sub-1"})
+	if !errors.Is(err, pkg.ErrAccountLocked) {
+		t.Fatalf("want ErrAccountLocked, got %v", err)
+	}
+}
+
+func TestLoginWithIdentityNeedsVerifiedEmail(t *testing.T) {
+	m := newTestModel(t)
+
+	_, err := m.LoginWithIdentity(context.Background(), ExternalIdentity{Provider: "google", Subject: "sub-1", Email: "x@example.com"})
+	if !errors.Is(err, pkg.ErrEmailNotVerified) {
+		t.Fatalf("want ErrEmailNotVerified, got %v", err)
+	}
+}
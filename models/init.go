@@ -1,6 +1,10 @@
 package models
 
 import (
+	"context"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
@@ -11,6 +15,28 @@ type Init struct {
 
 func Constructor(dbORM *gorm.DB, Logger *logrus.Logger) *Init {
 	return &Init{
-		UsersORM: UserModelORM{db: dbORM, logger: Logger},
+		UsersORM: UserModelORM{
+			db:         dbORM,
+			logger:     Logger,
+			accessTTL:  pkg.EnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+			refreshTTL: pkg.EnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+
+			activationTTL:    pkg.EnvDuration("ACTIVATION_TOKEN_TTL", 24*time.Hour),
+			activationResend: pkg.EnvDuration("ACTIVATION_RESEND_INTERVAL", 2*time.Minute),
+			resetTTL:         pkg.EnvDuration("PASSWORD_RESET_TTL", time.Hour),
+
+			loginDelayAfter:  pkg.EnvInt("LOGIN_DELAY_AFTER", 3),
+			lockoutThreshold: pkg.EnvInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+			lockoutDuration:  pkg.EnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		},
+	}
+}
+
+// log returns the request's log entry carried in ctx, so model log lines
+// share its request id and user, or a plain entry outside a request.
+func (m *UserModelORM) log(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value("logger").(*logrus.Entry); ok {
+		return entry
 	}
+	return logrus.NewEntry(m.logger)
 }
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"gorm.io/gorm"
+)
+
+// maxLoginDelay caps the wait between attempts before the lockout kicks in.
+const maxLoginDelay = time.Minute
+
+// LockoutNotice is what the owner is emailed when their account locks.
+type LockoutNotice struct {
+	Email       string
+	UnlockToken string
+	Until       time.Time
+}
+
+// LoginBlockedError is returned by LoginUser while an account has to wait
+// after failed attempts (Locked false) or is locked out (Locked true). The
+// attempt that locks the account also carries the Notice to email.
+type LoginBlockedError struct {
+	Until  time.Time
+	Locked bool
+	Notice *LockoutNotice
+}
+
+func (e *LoginBlockedError) Error() string {
+	if e.Locked {
+		return pkg.ErrAccountLocked.Error()
+	}
+	return pkg.ErrTooManyRequests.Error()
+}
+
+func (e *LoginBlockedError) Unwrap() error {
+	if e.Locked {
+		return pkg.ErrAccountLocked
+	}
+	return pkg.ErrTooManyRequests
+}
+
+// loginDelay is how long to wait after the failures-th failed attempt: none
+// for the first few, then doubling from a second.
+func (m *UserModelORM) loginDelay(failures int) time.Duration {
+	if failures < m.loginDelayAfter {
+		return 0
+	}
+	shift := failures - m.loginDelayAfter
+	if shift >= 6 {
+		return maxLoginDelay
+	}
+	return min(time.Second<<shift, maxLoginDelay)
+}
+
+// loginBlocked returns the block on user, if any, before the password is
+// even checked, so guesses made while blocked tell an attacker nothing.
+func (m *UserModelORM) loginBlocked(user *User, now time.Time) *LoginBlockedError {
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return &LoginBlockedError{Until: *user.LockedUntil, Locked: true}
+	}
+
+	if user.LastFailedLoginAt != nil {
+		until := user.LastFailedLoginAt.Add(m.loginDelay(user.FailedLogins))
+		if now.Before(until) {
+			return &LoginBlockedError{Until: until}
+		}
+	}
+	return nil
+}
+
+// loginFailed counts a wrong password against user. Reaching the lockout
+// threshold locks the account and returns the notice for the owner;
+// otherwise the answer is ErrInvalidCredentials.
+func (m *UserModelORM) loginFailed(ctx context.Context, user *User, now time.Time) error {
+	var failures int
+	var notice *LockoutNotice
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]any{
+			"failed_logins":        gorm.Expr("failed_logins + 1"),
+			"last_failed_login_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).Where("id = ?", user.ID).Pluck("failed_logins", &failures).Error; err != nil {
+			return err
+		}
+		if failures < m.lockoutThreshold {
+			return nil
+		}
+
+		token, err := generateRandomToken()
+		if err != nil {
+			return err
+		}
+		until := now.Add(m.lockoutDuration)
+		// the count starts over, so after the lock the next attempts get
+		// the short delays again before another lockout
+		err = tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]any{
+			"failed_logins":        0,
+			"last_failed_login_at": nil,
+			"locked_until":         until,
+			"unlock_token":         hashToken(token),
+		}).Error
+		notice = &LockoutNotice{Email: user.Email, UnlockToken: token, Until: until}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	m.recordLoginFailure(ctx, user.ID, "invalid_password", map[string]any{"failures": failures})
+	if notice == nil {
+		return pkg.ErrInvalidCredentials
+	}
+
+	if err := m.RecordEvent(ctx, Event{
+		Action:  ActionAccountLocked,
+		UserID:  user.ID,
+		Details: map[string]any{"until": notice.Until.UTC().Format(time.RFC3339), "failures": failures},
+	}); err != nil {
+		m.log(ctx).Error("Error logging account lockout ", err)
+	}
+	return &LoginBlockedError{Until: notice.Until, Locked: true, Notice: notice}
+}
+
+// recordLoginFailure logs a failed attempt on an existing account.
+func (m *UserModelORM) recordLoginFailure(ctx context.Context, userID uint, reason string, details map[string]any) {
+	if details == nil {
+		details = map[string]any{}
+	}
+	details["reason"] = reason
+
+	if err := m.RecordEvent(ctx, Event{Action: ActionLoginFailed, UserID: userID, Outcome: OutcomeFailure, Details: details}); err != nil {
+		m.log(ctx).Error("Error logging failed login ", err)
+	}
+}
+
+// clearLoginFailures forgets failed attempts after a successful login.
+func (m *UserModelORM) clearLoginFailures(ctx context.Context, user *User) error {
+	if user.FailedLogins == 0 && user.LastFailedLoginAt == nil && user.LockedUntil == nil {
+		return nil
+	}
+	return m.db.WithContext(ctx).Model(&User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"failed_logins":        0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+		"unlock_token":         "",
+	}).Error
+}
+
+// UnlockAccount lifts a lockout with the token from the lockout email. A
+// lock that already ran out counts as unlocked.
+func (m *UserModelORM) UnlockAccount(ctx context.Context, token string) error {
+	var user User
+	err := m.db.WithContext(ctx).Select("id").
+		Where("unlock_token = ?", hashToken(token)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return pkg.ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	err = m.db.WithContext(ctx).Model(&User{}).Where("id = ?", user.ID).Updates(map[string]any{
+		"failed_logins":        0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+		"unlock_token":         "",
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	return m.RecordEvent(ctx, Event{Action: ActionAccountUnlocked, UserID: user.ID, Details: map[string]any{"via": "email"}})
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestModel returns a model on a freshly migrated database: an SQLite
+// file of its own, or the MySQL or PostgreSQL database named by
+// TEST_DB_CONNECTION and TEST_DB_DSN, which is wiped first.
+func newTestModel(t *testing.T) *UserModelORM {
+	t.Helper()
+	t.Setenv("SIGNING_KEY", "test-signing-key")
+	// as openDBORM does for SQLite, see dbDialector
+	time.Local = time.UTC
+
+	// signingKey wants a .env file in the working directory
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	db := openTestDB(t, testDialector(t, dir))
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a shared database still holds the previous test's rows
+	if _, err := migrator.Down(context.Background(), math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return &Constructor(db, log).UsersORM
+}
+
+// testDialector picks the database from TEST_DB_CONNECTION, SQLite in dir
+// when it's unset.
+func testDialector(t *testing.T, dir string) gorm.Dialector {
+	t.Helper()
+	dsn := os.Getenv("TEST_DB_DSN")
+	switch connection := os.Getenv("TEST_DB_CONNECTION"); connection {
+	case "", "sqlite":
+		path := filepath.Join(dir, "test.db")
+		return sqlite.Open(path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate")
+	case "mysql":
+		return mysql.Open(dsn)
+	case "postgres":
+		return postgres.Open(dsn)
+	default:
+		t.Fatalf("unknown TEST_DB_CONNECTION %q, expected mysql, postgres or sqlite", connection)
+		return nil
+	}
+}
+
+// openTestDB opens a connection pool closed when the test ends.
+func openTestDB(t *testing.T, dialector gorm.Dialector) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger:  logger.Discard,
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// createUser inserts an account with password, without going through
+// registration.
+func createUser(t *testing.T, m *UserModelORM, user User, password string) User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.HashPassw = string(hash)
+	if err := m.db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func reloadUser(t *testing.T, m *UserModelORM, id uint) User {
+	t.Helper()
+	var user User
+	if err := m.db.First(&user, id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"gorm.io/gorm"
+)
+
+// resetRequestInterval is the minimum gap between two reset emails for the
+// same account, so the endpoint can't be used to flood someone's inbox.
+const resetRequestInterval = 2 * time.Minute
+
+// RequestPasswordReset creates a single-use reset token for email, replacing
+// any earlier unused one. ErrNoRecord means the email isn't registered and
+// ErrTooManyRequests that a link was sent moments ago; callers must answer
+// both the same way as success.
+func (m *UserModelORM) RequestPasswordReset(ctx context.Context, email string) (*ResetToken, error) {
+	var user User
+	err := m.db.WithContext(ctx).Select("id", "email").Where("email = ?", strings.TrimSpace(email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, pkg.ErrNoRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var recent int64
+	now := time.Now()
+	err = m.db.WithContext(ctx).Model(&PasswordReset{}).
+		Where("user_id = ? AND created_at > ?", user.ID, now.Add(-resetRequestInterval)).Count(&recent).Error
+	if err != nil {
+		return nil, err
+	}
+	if recent > 0 {
+		return nil, pkg.ErrTooManyRequests
+	}
+
+	ip, _ := ctx.Value("ip_addr").(string)
+	var reset *ResetToken
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		reset, err = m.issuePasswordReset(tx, &user, ip, now)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.RecordEvent(ctx, Event{Action: ActionPasswordResetRequest, UserID: user.ID}); err != nil {
+		return nil, err
+	}
+
+	return reset, nil
+}
+
+// issuePasswordReset stores a new reset link for user; only the newest
+// link works, so earlier unused ones are spent.
+func (m *UserModelORM) issuePasswordReset(tx *gorm.DB, user *User, ip string, now time.Time) (*ResetToken, error) {
+	token, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	reset := PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(m.resetTTL),
+		IpAddr:    ip,
+		CreatedAt: &now,
+	}
+
+	if err := tx.Model(&PasswordReset{}).Where("user_id = ? AND used_at IS NULL", user.ID).
+		Update("used_at", now).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&reset).Error; err != nil {
+		return nil, err
+	}
+
+	return &ResetToken{Email: user.Email, Token: token, ExpiresAt: reset.ExpiresAt}, nil
+}
+
+// ResetTokenValid lets the reset page tell the user up front that a link
+// is stale.
+func (m *UserModelORM) ResetTokenValid(ctx context.Context, token string) (bool, error) {
+	var count int64
+	err := m.db.WithContext(ctx).Model(&PasswordReset{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// ResetPassword consumes token, sets the new password and revokes every
+// session of the account so a stolen login doesn't survive the reset.
+func (m *UserModelORM) ResetPassword(ctx context.Context, token, password string) error {
+	hashedPassword, err := m.GeneratePassword(password)
+	if err != nil {
+		return err
+	}
+
+	var reset PasswordReset
+	now := time.Now()
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("token_hash = ?", hashToken(token)).First(&reset).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return pkg.ErrInvalidToken
+			}
+			return err
+		}
+
+		if reset.UsedAt != nil || now.After(reset.ExpiresAt) {
+			return pkg.ErrInvalidToken
+		}
+
+		result := tx.Model(&PasswordReset{}).Where("id = ? AND used_at IS NULL", reset.ID).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return pkg.ErrInvalidToken
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", reset.UserID).
+			Updates(map[string]any{
+				"hash_passw": string(hashedPassword),
+				"updated_at": now,
+				// a reset proves ownership, so it lifts a lockout too
+				"failed_logins":        0,
+				"last_failed_login_at": nil,
+				"locked_until":         nil,
+				"unlock_token":         "",
+			}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&UsersSession{}).Where("user_id = ? AND revoked_at IS NULL", reset.UserID).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.RecordEvent(ctx, Event{Action: ActionPasswordReset, UserID: reset.UserID})
+}
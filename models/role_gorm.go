@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/iamgak/go-drive/pkg"
+	"gorm.io/gorm"
+)
+
+// Roles a user can have. Auditors may read the activity log but change
+// nothing; admins may do everything.
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+)
+
+var roles = []string{RoleUser, RoleAdmin, RoleAuditor}
+
+// UserRole reads the user's current role; the role in an access token may be
+// up to ACCESS_TOKEN_TTL out of date.
+func (m *UserModelORM) UserRole(ctx context.Context, userID uint) (string, error) {
+	var user User
+	if err := m.db.WithContext(ctx).Select("id", "role", "active").First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", pkg.ErrUserNotFound
+		}
+		return "", err
+	}
+
+	if !user.Active {
+		return "", pkg.ErrAccountInActive
+	}
+	return user.Role, nil
+}
+
+// SetUserRole changes a user's role on behalf of adminID. Admins can't
+// change their own role, so the last admin can't lock everyone out.
+func (m *UserModelORM) SetUserRole(ctx context.Context, adminID, userID uint, role string) error {
+	if !slices.Contains(roles, role) {
+		return pkg.ErrInvalidRole
+	}
+	if adminID == userID {
+		return pkg.ErrInvalidUserFound
+	}
+
+	result := m.db.WithContext(ctx).Model(&User{}).Where("id = ?", userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return pkg.ErrUserNotFound
+	}
+
+	return m.adminEvent(ctx, adminID, userID, ActionRoleChanged, map[string]any{"role": role})
+}
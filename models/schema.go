@@ -0,0 +1,73 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/iamgak/go-drive/migrations"
+	"github.com/iamgak/go-drive/pkg/migrate"
+	"gorm.io/gorm"
+)
+
+// Tables are the models in dependency order. A database from before
+// versioned migrations is brought up to them with AutoMigrate once and then
+// recorded at migration 0001, which only holds while the models match 0001:
+// after a later migration changes them, such a database has to be adopted by
+// an older release first.
+func Tables() []any {
+	return []any{
+		&User{},
+		&UsersSession{},
+		&UserActivityLog{},
+		&PasswordReset{},
+		&RecoveryCode{},
+		&PersonalAccessToken{},
+		&UserIdentity{},
+	}
+}
+
+// schemaChecked remembers a successful SchemaCurrent; a schema doesn't go
+// out of date again while the process runs.
+var schemaChecked atomic.Bool
+
+// Ping checks the database answers.
+func (m *UserModelORM) Ping(ctx context.Context) error {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// NewMigrator returns the migrator with the migrations for db's database.
+func NewMigrator(db *gorm.DB) (*migrate.Migrator, error) {
+	fsys, err := migrations.For(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return migrate.New(db, fsys)
+}
+
+// SchemaCurrent returns an error naming the first migration of this build
+// the database hasn't had, i.e. migrations haven't run for it.
+func (m *UserModelORM) SchemaCurrent(ctx context.Context) error {
+	if schemaChecked.Load() {
+		return nil
+	}
+
+	migrator, err := NewMigrator(m.db)
+	if err != nil {
+		return err
+	}
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("migration %s has not been applied", pending[0])
+	}
+
+	schemaChecked.Store(true)
+	return nil
+}
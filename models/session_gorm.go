@@ -0,0 +1,282 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/iamgak/go-drive/pkg"
+	"gorm.io/gorm"
+)
+
+// CreateSession starts a new session family for a fresh login and returns
+// its first access/refresh token pair.
+func (m *UserModelORM) CreateSession(ctx context.Context, user *User) (*AuthTokens, error) {
+	familyID, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	ip, _ := ctx.Value("ip_addr").(string)
+	userAgent, _ := ctx.Value("user_agent").(string)
+	session := UsersSession{
+		UserID:     user.ID,
+		FamilyID:   familyID,
+		UserAgent:  truncate(userAgent, 512),
+		IpAddr:     ip,
+		SignedInAt: time.Now(),
+	}
+
+	var tokens *AuthTokens
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tokens, err = m.issueTokens(tx, session, user)
+		return err
+	})
+	return tokens, err
+}
+
+// RefreshSession swaps a refresh token for a new pair. Each refresh token
+// works once: presenting one that was already rotated means it leaked, so
+// the whole family is revoked and the caller has to log in again.
+func (m *UserModelORM) RefreshSession(ctx context.Context, refreshToken string) (*AuthTokens, error) {
+	var tokens *AuthTokens
+	var reused *UsersSession
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var session UsersSession
+		if err := tx.Where("refresh_token_hash = ?", hashToken(refreshToken)).First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return pkg.ErrInvalidToken
+			}
+			return err
+		}
+
+		if session.RevokedAt != nil {
+			return pkg.ErrSessionRevoked
+		}
+
+		if session.RotatedAt != nil {
+			reused = &session
+			return pkg.ErrRefreshTokenReused
+		}
+
+		if time.Now().After(session.ExpiresAt) {
+			return pkg.ErrInvalidToken
+		}
+
+		// the rotated_at guard makes two concurrent refreshes with the same
+		// token count as reuse instead of both succeeding
+		result := tx.Model(&UsersSession{}).Where("id = ? AND rotated_at IS NULL", session.ID).Update("rotated_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = &session
+			return pkg.ErrRefreshTokenReused
+		}
+
+		var user User
+		if err := tx.Select("id", "email", "active", "role").First(&user, session.UserID).Error; err != nil {
+			return err
+		}
+		if !user.Active {
+			return pkg.ErrAccountInActive
+		}
+
+		// the rotated token keeps the device details, refreshed with where
+		// the client is calling from now
+		next := UsersSession{
+			UserID:     session.UserID,
+			FamilyID:   session.FamilyID,
+			UserAgent:  session.UserAgent,
+			IpAddr:     session.IpAddr,
+			SignedInAt: session.SignedInAt,
+			LastSeenAt: session.LastSeenAt,
+		}
+		if ip, _ := ctx.Value("ip_addr").(string); ip != "" {
+			next.IpAddr = ip
+		}
+		if userAgent, _ := ctx.Value("user_agent").(string); userAgent != "" {
+			next.UserAgent = truncate(userAgent, 512)
+		}
+
+		var err error
+		tokens, err = m.issueTokens(tx, next, &user)
+		return err
+	})
+
+	if reused != nil {
+		if err := m.RevokeSessionFamily(ctx, reused.FamilyID); err != nil {
+			return nil, err
+		}
+
+		event := Event{Action: ActionRefreshReused, UserID: reused.UserID, Outcome: OutcomeFailure, Details: map[string]any{"session": reused.FamilyID}}
+		if err := m.RecordEvent(ctx, event); err != nil {
+			m.log(ctx).Error("Error logging refresh token reuse ", err)
+		}
+	}
+
+	return tokens, err
+}
+
+// RevokeSessionFamily ends every token issued for one login.
+func (m *UserModelORM) RevokeSessionFamily(ctx context.Context, familyID string) error {
+	return m.db.WithContext(ctx).Model(&UsersSession{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserSessions logs a user out everywhere.
+func (m *UserModelORM) RevokeUserSessions(ctx context.Context, userID uint) error {
+	return m.db.WithContext(ctx).Model(&UsersSession{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RefreshTokenFamily returns the session family refreshToken was issued
+// for, rotated or not, so /refresh and /logout can check the CSRF token
+// without an access token.
+func (m *UserModelORM) RefreshTokenFamily(ctx context.Context, refreshToken string) (string, error) {
+	var session UsersSession
+	if err := m.db.WithContext(ctx).Select("family_id").
+		Where("refresh_token_hash = ?", hashToken(refreshToken)).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", pkg.ErrInvalidToken
+		}
+		return "", err
+	}
+	return session.FamilyID, nil
+}
+
+// LogoutSession revokes the family the refresh token belongs to and returns
+// the owning user id.
+func (m *UserModelORM) LogoutSession(ctx context.Context, refreshToken string) (uint, error) {
+	var session UsersSession
+	if err := m.db.WithContext(ctx).Select("user_id", "family_id").
+		Where("refresh_token_hash = ?", hashToken(refreshToken)).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, pkg.ErrInvalidToken
+		}
+		return 0, err
+	}
+
+	return session.UserID, m.RevokeSessionFamily(ctx, session.FamilyID)
+}
+
+// SessionActive reports whether the family an access token was issued for
+// is still usable, i.e. has not been revoked by logout or reuse detection.
+func (m *UserModelORM) SessionActive(ctx context.Context, familyID string) (bool, error) {
+	var count int64
+	err := m.db.WithContext(ctx).Model(&UsersSession{}).
+		Where("family_id = ? AND revoked_at IS NULL AND expires_at > ?", familyID, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// issueTokens stores session as the family's current refresh token and signs
+// the matching access token.
+func (m *UserModelORM) issueTokens(tx *gorm.DB, session UsersSession, user *User) (*AuthTokens, error) {
+	refreshToken, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.RefreshTokenHash = hashToken(refreshToken)
+	session.ExpiresAt = now.Add(m.refreshTTL)
+	if err := tx.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	accessToken, err := m.generateToken(user, session.FamilyID, now.Add(m.accessTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthTokens{
+		AccessToken:      accessToken,
+		AccessExpiresAt:  now.Add(m.accessTTL),
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// TouchSession records that the family was just used. Writes are throttled
+// to one a minute so busy clients don't update the row on every request.
+func (m *UserModelORM) TouchSession(ctx context.Context, familyID string) error {
+	now := time.Now()
+	return m.db.WithContext(ctx).Model(&UsersSession{}).
+		Where("family_id = ? AND rotated_at IS NULL AND (last_seen_at IS NULL OR last_seen_at < ?)", familyID, now.Add(-time.Minute)).
+		Update("last_seen_at", now).Error
+}
+
+// ActiveSessions lists the user's live logins, one per family, flagging the
+// one currentFamily belongs to.
+func (m *UserModelORM) ActiveSessions(ctx context.Context, userID uint, currentFamily string) ([]SessionInfo, error) {
+	var rows []UsersSession
+	err := m.db.WithContext(ctx).
+		Where("user_id = ? AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("signed_in_at DESC").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]SessionInfo, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, SessionInfo{
+			ID:         row.FamilyID,
+			UserAgent:  row.UserAgent,
+			IpAddr:     row.IpAddr,
+			SignedInAt: row.SignedInAt,
+			LastSeenAt: row.LastSeenAt,
+			ExpiresAt:  row.ExpiresAt,
+			Current:    row.FamilyID == currentFamily,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeUserSession revokes one of the user's own sessions; the user_id
+// condition keeps users from revoking someone else's family.
+func (m *UserModelORM) RevokeUserSession(ctx context.Context, userID uint, familyID string) error {
+	result := m.db.WithContext(ctx).Model(&UsersSession{}).
+		Where("user_id = ? AND family_id = ? AND revoked_at IS NULL", userID, familyID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return pkg.ErrNoRecord
+	}
+	return nil
+}
+
+// RevokeOtherSessions logs the user out everywhere except keepFamily.
+func (m *UserModelORM) RevokeOtherSessions(ctx context.Context, userID uint, keepFamily string) (int64, error) {
+	var families int64
+	err := m.db.WithContext(ctx).Model(&UsersSession{}).
+		Where("user_id = ? AND family_id <> ? AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userID, keepFamily, time.Now()).
+		Count(&families).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = m.db.WithContext(ctx).Model(&UsersSession{}).
+		Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, keepFamily).
+		Update("revoked_at", time.Now()).Error
+	return families, err
+}
+
+// truncate cuts s to at most n bytes, at a rune boundary so what's stored
+// is still valid UTF-8; PostgreSQL refuses anything else.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
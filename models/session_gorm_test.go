@@ -0,0 +1,41 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exact", 5, "exact"},
+		{"abcdef", 3, "abc"},
+		// é is two bytes, cutting after its first would split it
+		{"caféx", 4, "caf"},
+		{"café", 5, "café"},
+		// € is three bytes, 😀 four
+		{"a€", 2, "a"},
+		{"a€", 3, "a"},
+		{"a€b", 4, "a€"},
+		{"😀😀", 7, "😀"},
+		{"😀", 3, ""},
+		{"", 0, ""},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.n)
+		if got != tt.want || !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+
+	// a user agent with a multi-byte rune across the 512 byte cut
+	ua := strings.Repeat("a", 511) + "Ω" + "tail"
+	if got := truncate(ua, 512); len(got) != 511 || !utf8.ValidString(got) {
+		t.Errorf("truncate cut the user agent to %d bytes, valid UTF-8 %t", len(got), utf8.ValidString(got))
+	}
+}
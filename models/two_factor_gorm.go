@@ -0,0 +1,296 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/iamgak/go-drive/pkg"
+	"github.com/iamgak/go-drive/pkg/totp"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaPurpose        = "mfa"
+	mfaTokenTTL       = 5 * time.Minute
+	recoveryCodeCount = 10
+	// maxTwoFactorFailures caps wrong codes per mfaTokenTTL window; without it
+	// the 6 digit space could be brute forced with one password check
+	maxTwoFactorFailures = 5
+)
+
+// SetupTwoFactor stores a new, not yet enforced, TOTP secret for the user.
+// Calling it again before confirming simply replaces the secret.
+func (m *UserModelORM) SetupTwoFactor(ctx context.Context, userID uint, issuer string) (*TwoFactorSetup, error) {
+	var user User
+	if err := m.db.WithContext(ctx).Select("id", "email", "totp_enabled").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return nil, pkg.ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.db.WithContext(ctx).Model(&user).Update("totp_secret", secret).Error; err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(issuer, user.Email, secret),
+	}, nil
+}
+
+// PendingTwoFactorURI returns the provisioning URI of a secret that was set
+// up but not confirmed yet, used to draw the QR code.
+func (m *UserModelORM) PendingTwoFactorURI(ctx context.Context, userID uint, issuer string) (string, error) {
+	var user User
+	if err := m.db.WithContext(ctx).Select("id", "email", "totp_secret", "totp_enabled").First(&user, userID).Error; err != nil {
+		return "", err
+	}
+
+	if user.TOTPEnabled {
+		return "", pkg.ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return "", pkg.ErrNoRecord
+	}
+
+	return totp.ProvisioningURI(issuer, user.Email, user.TOTPSecret), nil
+}
+
+// ConfirmTwoFactor turns 2FA on once the user proves the authenticator
+// works, and returns freshly generated recovery codes. They are only stored
+// hashed, so this is the one time the user gets to see them.
+func (m *UserModelORM) ConfirmTwoFactor(ctx context.Context, userID uint, code string) ([]string, error) {
+	var user User
+	if err := m.db.WithContext(ctx).Select("id", "totp_secret", "totp_enabled").First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return nil, pkg.ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, pkg.ErrTwoFactorNotEnabled
+	}
+
+	counter, ok := totp.Validate(user.TOTPSecret, code, time.Now(), 0)
+	if !ok {
+		return nil, pkg.ErrInvalidTwoFactorCode
+	}
+
+	codes, rows, err := newRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{
+			"totp_enabled":      true,
+			"totp_last_counter": counter,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, m.RecordEvent(ctx, Event{Action: ActionTwoFactorEnabled, UserID: userID})
+}
+
+// DisableTwoFactor turns 2FA off after re-checking the account password.
+func (m *UserModelORM) DisableTwoFactor(ctx context.Context, userID uint, password string) error {
+	var user User
+	if err := m.db.WithContext(ctx).Select("id", "hash_passw", "totp_enabled").First(&user, userID).Error; err != nil {
+		return err
+	}
+
+	if !user.TOTPEnabled {
+		return pkg.ErrTwoFactorNotEnabled
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.HashPassw), []byte(password)); err != nil {
+		return pkg.ErrIncorrectPassword
+	}
+
+	err := m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{
+			"totp_enabled":      false,
+			"totp_secret":       "",
+			"totp_last_counter": 0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.RecordEvent(ctx, Event{Action: ActionTwoFactorDisabled, UserID: userID})
+}
+
+// CompleteTwoFactorLogin is the second login step: it accepts either the
+// current TOTP code or an unused recovery code and then starts the session.
+func (m *UserModelORM) CompleteTwoFactorLogin(ctx context.Context, mfaToken, code string) (*AuthTokens, error) {
+	userID, err := m.parseMFAToken(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var user User
+	if err := m.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, pkg.ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	if !user.Active {
+		return nil, pkg.ErrAccountInActive
+	}
+	if !user.TOTPEnabled {
+		return nil, pkg.ErrInvalidToken
+	}
+
+	var failures int64
+	err = m.db.WithContext(ctx).Model(&UserActivityLog{}).
+		Where("user_id = ? AND action = ? AND created_at > ?", user.ID, ActionTwoFactorFailed, time.Now().Add(-mfaTokenTTL)).
+		Count(&failures).Error
+	if err != nil {
+		return nil, err
+	}
+	if failures >= maxTwoFactorFailures {
+		return nil, pkg.ErrTooManyRequests
+	}
+
+	ok, err := m.checkSecondFactor(ctx, &user, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		if err := m.RecordEvent(ctx, Event{Action: ActionTwoFactorFailed, UserID: user.ID, Outcome: OutcomeFailure}); err != nil {
+			m.log(ctx).Error("Error logging failed two factor attempt ", err)
+		}
+		return nil, pkg.ErrInvalidTwoFactorCode
+	}
+
+	return m.startSession(ctx, &user)
+}
+
+// checkSecondFactor consumes a TOTP or recovery code. The conditional updates
+// make each code single-use even under concurrent requests.
+func (m *UserModelORM) checkSecondFactor(ctx context.Context, user *User, code string) (bool, error) {
+	if counter, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastCounter); ok {
+		result := m.db.WithContext(ctx).Model(&User{}).
+			Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+			Update("totp_last_counter", counter)
+		return result.RowsAffected == 1, result.Error
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return false, nil
+	}
+
+	result := m.db.WithContext(ctx).Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalized)).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func (m *UserModelORM) generateMFAToken(userID uint) (string, error) {
+	signingKey, err := m.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	claims := MFAClaims{
+		UserID:  userID,
+		Purpose: mfaPurpose,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(mfaTokenTTL).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(signingKey)
+}
+
+func (m *UserModelORM) parseMFAToken(tokenString string) (uint, error) {
+	signingKey, err := m.signingKey()
+	if err != nil {
+		return 0, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &MFAClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return signingKey, nil
+	})
+	if err != nil {
+		return 0, pkg.ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(*MFAClaims)
+	if !ok || !token.Valid || claims.Purpose != mfaPurpose || claims.UserID == 0 {
+		return 0, pkg.ErrInvalidToken
+	}
+	return claims.UserID, nil
+}
+
+// newRecoveryCodes returns the codes to show the user and the hashed rows to
+// store, formatted as "xxxxx-xxxxx".
+func newRecoveryCodes(userID uint) ([]string, []RecoveryCode, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]RecoveryCode, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		raw := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		rows = append(rows, RecoveryCode{UserID: userID, CodeHash: hashToken(raw)})
+	}
+	return codes, rows, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != 10 {
+		return ""
+	}
+	return code
+}
+
+// TwoFactorEnabled reports whether the user has confirmed 2FA.
+func (m *UserModelORM) TwoFactorEnabled(ctx context.Context, userID uint) (bool, error) {
+	var user User
+	err := m.db.WithContext(ctx).Select("id", "totp_enabled").First(&user, userID).Error
+	return user.TOTPEnabled, err
+}
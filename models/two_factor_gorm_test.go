@@ -0,0 +1,137 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iamgak/go-drive/pkg"
+	"github.com/iamgak/go-drive/pkg/totp"
+)
+
+// enableTwoFactor turns 2FA on for user and returns the secret and the
+// recovery codes.
+func enableTwoFactor(t *testing.T, m *UserModelORM, user User) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	setup, err := m.SetupTwoFactor(ctx, user.ID, "Drive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := totp.CodeAt(setup.Secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := m.ConfirmTwoFactor(ctx, user.ID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("%d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+	return setup.Secret, codes
+}
+
+// mfaToken signs in with the password and returns the second step's token.
+func mfaToken(t *testing.T, m *UserModelORM, email, password string) string {
+	t.Helper()
+	result, err := m.LoginUser(context.Background(), &UserStruct{Email: email, Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.MFAToken == "" || result.Tokens != nil {
+		t.Fatalf("login with 2FA on returned %+v, want only an MFA token", result)
+	}
+	return result.MFAToken
+}
+
+func TestTwoFactorRecoveryCode(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+	user := createUser(t, m, User{Email: "user@example.com", Active: true}, "password")
+	_, codes := enableTwoFactor(t, m, user)
+
+	token := mfaToken(t, m, user.Email, "password")
+	if _, err := m.CompleteTwoFactorLogin(ctx, token, codes[0]); err != nil {
+		t.Fatalf("first use of a recovery code: %v", err)
+	}
+	if _, err := m.CompleteTwoFactorLogin(ctx, token, codes[0]); !errors.Is(err, pkg.ErrInvalidTwoFactorCode) {
+		t.Fatalf("second use of a recovery code: %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	// typed without the dash and in upper case it is still the same code
+	other := strings.ToUpper(strings.ReplaceAll(codes[1], "-", ""))
+	if _, err := m.CompleteTwoFactorLogin(ctx, token, other); err != nil {
+		t.Fatalf("recovery code typed differently: %v", err)
+	}
+	if _, err := m.CompleteTwoFactorLogin(ctx, token, codes[1]); !errors.Is(err, pkg.ErrInvalidTwoFactorCode) {
+		t.Fatalf("reuse of a recovery code typed differently: %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	// another user's codes don't work
+	stranger := createUser(t, m, User{Email: "stranger@example.com", Active: true}, "password")
+	enableTwoFactor(t, m, stranger)
+	if _, err := m.CompleteTwoFactorLogin(ctx, mfaToken(t, m, stranger.Email, "password"), codes[2]); !errors.Is(err, pkg.ErrInvalidTwoFactorCode) {
+		t.Fatalf("someone else's recovery code: %v, want ErrInvalidTwoFactorCode", err)
+	}
+}
+
+func TestTwoFactorCodeReplay(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+	user := createUser(t, m, User{Email: "user@example.com", Active: true}, "password")
+	secret, _ := enableTwoFactor(t, m, user)
+	token := mfaToken(t, m, user.Email, "password")
+
+	// the code that confirmed 2FA is spent already
+	last := reloadUser(t, m, user.ID).TOTPLastCounter
+	current, _ := totp.CodeAt(secret, last)
+	if _, err := m.CompleteTwoFactorLogin(ctx, token, current); !errors.Is(err, pkg.ErrInvalidTwoFactorCode) {
+		t.Fatalf("code used to confirm 2FA: %v, want ErrInvalidTwoFactorCode", err)
+	}
+
+	next, _ := totp.CodeAt(secret, last+1)
+	if _, err := m.CompleteTwoFactorLogin(ctx, token, next); err != nil {
+		t.Fatalf("next code: %v", err)
+	}
+	if _, err := m.CompleteTwoFactorLogin(ctx, token, next); !errors.Is(err, pkg.ErrInvalidTwoFactorCode) {
+		t.Fatalf("replayed code: %v, want ErrInvalidTwoFactorCode", err)
+	}
+}
+
+func TestDisableTwoFactor(t *testing.T) {
+	m := newTestModel(t)
+	ctx := context.Background()
+	user := createUser(t, m, User{Email: "user@example.com", Active: true}, "password")
+	enableTwoFactor(t, m, user)
+
+	if err := m.DisableTwoFactor(ctx, user.ID, "wrong"); !errors.Is(err, pkg.ErrIncorrectPassword) {
+		t.Fatalf("disable with a wrong password: %v, want ErrIncorrectPassword", err)
+	}
+	if got := reloadUser(t, m, user.ID); !got.TOTPEnabled || got.TOTPSecret == "" {
+		t.Fatal("a wrong password turned 2FA off")
+	}
+
+	if err := m.DisableTwoFactor(ctx, user.ID, "password"); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloadUser(t, m, user.ID); got.TOTPEnabled || got.TOTPSecret != "" {
+		t.Fatal("2FA still on after disabling it")
+	}
+	var codes int64
+	if err := m.db.Model(&RecoveryCode{}).Where("user_id = ?", user.ID).Count(&codes).Error; err != nil {
+		t.Fatal(err)
+	}
+	if codes != 0 {
+		t.Fatalf("%d recovery codes left after disabling 2FA", codes)
+	}
+
+	if err := m.DisableTwoFactor(ctx, user.ID, "password"); !errors.Is(err, pkg.ErrTwoFactorNotEnabled) {
+		t.Fatalf("disable twice: %v, want ErrTwoFactorNotEnabled", err)
+	}
+	if result, err := m.LoginUser(ctx, &UserStruct{Email: user.Email, Password: "password"}); err != nil || result.Tokens == nil {
+		t.Fatalf("login after disabling 2FA: %+v, %v, want a session", result, err)
+	}
+}
@@ -1,20 +1,38 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 )
 
+// User is an account. ActivationToken holds the sha256 of the emailed
+// activation token, valid until ActivationExpiresAt; ActivationSentAt
+// throttles resends. TOTPSecret is set from 2FA setup on but only enforced
+// once TOTPEnabled; TOTPLastCounter stops a code being used twice. Role is
+// one of RoleUser, RoleAdmin or RoleAuditor. QuotaBytes caps the size of
+// the user's drive, zero meaning no limit.
 type User struct {
-	ID              uint       `gorm:"primaryKey" json:"id" binding:"-"`
-	Email           string     `gorm:"unique;not null" json:"email"`
-	HashPassw       string     `gorm:"not null"`
-	ActivationToken string     `gorm:"index"`
-	Active          bool       `gorm:"default:false" json:"-"`
-	VerifiedAt      time.Time  `gorm:"default:null"`
-	CreatedAt       *time.Time `gorm:"type:datetime;default:CURRENT_TIMESTAMP()" json:"created_at,omitempty" binding:"-"`
-	UpdatedAt       *time.Time `gorm:"default:null" json:"-" binding:"-"`
+	ID                  uint       `gorm:"primaryKey" json:"id" binding:"-"`
+	Email               string     `gorm:"unique;not null" json:"email"`
+	HashPassw           string     `gorm:"not null"`
+	ActivationToken     string     `gorm:"index" json:"-"`
+	ActivationExpiresAt *time.Time `gorm:"default:null" json:"-"`
+	ActivationSentAt    *time.Time `gorm:"default:null" json:"-"`
+	Active              bool       `gorm:"default:false" json:"-"`
+	Role                string     `gorm:"size:16;not null;default:user" json:"role"`
+	QuotaBytes          int64      `gorm:"not null;default:0" json:"quota_bytes"`
+	TOTPSecret          string     `gorm:"column:totp_secret;size:64" json:"-"`
+	TOTPEnabled         bool       `gorm:"column:totp_enabled;default:false" json:"-"`
+	TOTPLastCounter     int64      `gorm:"column:totp_last_counter;default:0" json:"-"`
+	VerifiedAt          time.Time  `gorm:"default:null"`
+	FailedLogins        int        `gorm:"not null;default:0" json:"-"`
+	LastFailedLoginAt   *time.Time `gorm:"default:null" json:"-"`
+	LockedUntil         *time.Time `gorm:"default:null" json:"-"`
+	UnlockToken         string     `gorm:"size:64;index" json:"-"`
+	CreatedAt           *time.Time `json:"created_at,omitempty" binding:"-"`
+	UpdatedAt           *time.Time `gorm:"default:null" json:"-" binding:"-"`
 }
 
 type UserStruct struct {
@@ -23,25 +41,210 @@ type UserStruct struct {
 	RepeatPassword string `json:"repeatPassword"`
 }
 
+// UsersSession holds one refresh token. Every refresh rotates the token into
+// a new row sharing the FamilyID of the original login, so a whole login can
+// be revoked at once.
 type UsersSession struct {
+	ID               uint       `gorm:"primaryKey"`
+	UserID           uint       `gorm:"index"`
+	FamilyID         string     `gorm:"size:64;index;not null"`
+	RefreshTokenHash string     `gorm:"size:64;uniqueIndex;not null"`
+	UserAgent        string     `gorm:"size:512"`
+	IpAddr           string     `gorm:"default:null"`
+	SignedInAt       time.Time  `gorm:"not null"`
+	LastSeenAt       *time.Time `gorm:"default:null"`
+	ExpiresAt        time.Time  `gorm:"not null"`
+	RotatedAt        *time.Time `gorm:"default:null"`
+	RevokedAt        *time.Time `gorm:"default:null"`
+	CreatedAt        *time.Time
+}
+
+// PasswordReset is one emailed reset link. Only the token hash is stored and
+// a row can be used once.
+type PasswordReset struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index"`
+	TokenHash string     `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+	IpAddr    string     `gorm:"default:null"`
+	CreatedAt *time.Time
+}
+
+// UserIdentity links a user to an account at an external OpenID Connect
+// provider, identified by the provider's stable subject.
+type UserIdentity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Provider  string `gorm:"size:64;not null;uniqueIndex:idx_provider_subject"`
+	Subject   string `gorm:"size:255;not null;uniqueIndex:idx_provider_subject"`
+	Email     string `gorm:"size:255"`
+	CreatedAt *time.Time
+}
+
+// PersonalAccessToken lets scripts call the API without a browser login.
+// Scopes is a space separated list; Prefix is the start of the raw token,
+// kept so users can tell their tokens apart.
+type PersonalAccessToken struct {
 	ID         uint       `gorm:"primaryKey"`
 	UserID     uint       `gorm:"index"`
-	LoginToken string     `gorm:"not null"`
-	CreatedAt  *time.Time `gorm:"type:datetime;default:CURRENT_TIMESTAMP()"`
+	Name       string     `gorm:"size:100;not null"`
+	Prefix     string     `gorm:"size:16;not null"`
+	TokenHash  string     `gorm:"size:64;uniqueIndex;not null"`
+	Scopes     string     `gorm:"size:255;not null"`
+	ExpiresAt  *time.Time `gorm:"default:null"`
+	LastUsedAt *time.Time `gorm:"default:null"`
+	RevokedAt  *time.Time `gorm:"default:null"`
+	CreatedAt  *time.Time
+}
+
+// RecoveryCode is a hashed single-use code that replaces a TOTP code when
+// the authenticator is lost.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"index"`
+	CodeHash  string     `gorm:"size:64;not null"`
+	UsedAt    *time.Time `gorm:"default:null"`
+	CreatedAt *time.Time
+}
+
+// LoginResult is either a finished login (Tokens) or, for accounts with 2FA,
+// the MFAToken to present together with a code at /login/2fa.
+type LoginResult struct {
+	Tokens   *AuthTokens
+	MFAToken string
+}
+
+// TwoFactorSetup is shown once when enrolling an authenticator app.
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// SessionInfo describes one logged in device on the sessions page.
+type SessionInfo struct {
+	ID         string     `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IpAddr     string     `json:"ip_addr"`
+	SignedInAt time.Time  `json:"signed_in_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Current    bool       `json:"current"`
+}
+
+// AccessTokenInfo describes a personal access token without its secret.
+type AccessTokenInfo struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  *time.Time `json:"created_at"`
+}
+
+// NewAccessToken is returned once on creation; Token is never shown again.
+type NewAccessToken struct {
+	AccessTokenInfo
+	Token string `json:"token"`
 }
 
+// AccessTokenOwner is who a valid personal access token acts for.
+type AccessTokenOwner struct {
+	TokenID uint
+	UserID  uint
+	Email   string
+	Role    string
+	Scopes  []string
+}
+
+// AdminUser is a user as the admin API shows it. UsageBytes is filled in
+// from the drive directory, not the database.
+type AdminUser struct {
+	ID          uint       `json:"id"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	Active      bool       `json:"active"`
+	TOTPEnabled bool       `gorm:"column:totp_enabled" json:"two_factor"`
+	QuotaBytes  int64      `json:"quota_bytes"`
+	UsageBytes  int64      `gorm:"-" json:"usage_bytes"`
+	LastLoginAt *Timestamp `json:"last_login_at"`
+	CreatedAt   *time.Time `json:"created_at"`
+}
+
+// ActivationToken is the raw token emailed to a new user.
+type ActivationToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// ResetToken is the raw password reset token emailed to Email.
+type ResetToken struct {
+	Email     string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// AuthTokens is what a successful login or refresh hands back to the client.
+type AuthTokens struct {
+	AccessToken      string    `json:"access_token"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// UserActivityLog is one event in a user's history, written through
+// RecordEvent. ActorID is set when someone else, an admin, acted on the
+// account. Activity is a readable summary derived from the other fields;
+// Details holds action specific extras as a JSON object.
 type UserActivityLog struct {
-	ID         uint       `gorm:"primaryKey"`
-	UserID     uint       `gorm:"index"`
-	Activity   string     `gorm:"not null"`
-	Superseded bool       `gorm:"default:0"`
-	IpAddr     string     `gorm:"default:null"`
-	CreatedAt  *time.Time `gorm:"type:datetime;default:CURRENT_TIMESTAMP()"`
-	UpdatedAt  *time.Time `gorm:"default:null"`
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	UserID     uint            `gorm:"index" json:"user_id"`
+	ActorID    *uint           `gorm:"index;default:null" json:"actor_id"`
+	Action     Action          `gorm:"size:64;index;not null;default:''" json:"action"`
+	Activity   string          `gorm:"not null" json:"activity"`
+	TargetPath string          `gorm:"size:1024" json:"target_path,omitempty"`
+	OldPath    string          `gorm:"size:1024" json:"old_path,omitempty"`
+	Size       int64           `gorm:"default:0" json:"size,omitempty"`
+	Outcome    string          `gorm:"size:16;not null;default:success" json:"outcome"`
+	Details    json.RawMessage `gorm:"type:text" json:"details,omitempty"`
+	Superseded bool            `gorm:"default:false" json:"-"`
+	IpAddr     string          `gorm:"default:null" json:"ip_addr"`
+	UserAgent  string          `gorm:"size:512" json:"user_agent,omitempty"`
+	RequestID  string          `gorm:"size:64" json:"request_id,omitempty"`
+	// PrevHash and UserPrevHash link the entry to the one before it in the
+	// whole log and in this user's log; Hash covers both and the entry itself.
+	PrevHash     string     `gorm:"size:64" json:"prev_hash,omitempty"`
+	UserPrevHash string     `gorm:"size:64" json:"user_prev_hash,omitempty"`
+	Hash         string     `gorm:"size:64;index" json:"hash,omitempty"`
+	CreatedAt    *time.Time `json:"created_at"`
+	UpdatedAt    *time.Time `gorm:"default:null" json:"-"`
+}
+
+// ActivityFilter narrows an activity query. Zero fields don't filter; Path
+// matches the target or old path of an event.
+type ActivityFilter struct {
+	UserID uint
+	From   time.Time
+	To     time.Time
+	Action Action
+	Path   string
+}
+
+// MFAClaims identify a user that passed the password check and still owes a
+// second factor. They can't be used as an access token: no session id.
+type MFAClaims struct {
+	UserID  uint   `json:"user_id"`
+	Purpose string `json:"purpose"`
+	jwt.StandardClaims
 }
 
+// MyCustomClaims are the access token claims. Role is only a hint for the
+// client and for cheap rejections; admin checks re-read it from the database.
 type MyCustomClaims struct {
-	Email  string `json:"email"`
-	UserID uint   `json:"user_id"`
+	Email     string `json:"email"`
+	UserID    uint   `json:"user_id"`
+	SessionID string `json:"sid"`
+	Role      string `json:"role"`
 	jwt.StandardClaims
 }
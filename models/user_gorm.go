@@ -2,12 +2,7 @@ package models
 
 import (
 	"context"
-	"crypto/sha1"
-	"database/sql"
-	"encoding/hex"
 	"errors"
-	"fmt"
-	"math/rand"
 	"os"
 	"strings"
 	"time"
@@ -21,110 +16,209 @@ import (
 )
 
 type UserModelORM struct {
-	db     *gorm.DB
-	logger *logrus.Logger
+	db         *gorm.DB
+	logger     *logrus.Logger
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+
+	activationTTL    time.Duration
+	activationResend time.Duration
+	resetTTL         time.Duration
+
+	// failed logins: delays from loginDelayAfter on, lockout at
+	// lockoutThreshold
+	loginDelayAfter  int
+	lockoutThreshold int
+	lockoutDuration  time.Duration
 }
 
-func (m *UserModelORM) RegisterUser(ctx context.Context, email, password, ip string) error {
+// RegisterUser creates an inactive account and returns the activation token
+// to email to the user; only its hash is stored.
+func (m *UserModelORM) RegisterUser(ctx context.Context, email, password string) (*ActivationToken, error) {
 	hashedPassword, err := m.GeneratePassword(password)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	activation, err := m.newActivationToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := User{
+		Email:               email,
+		HashPassw:           string(hashedPassword),
+		ActivationToken:     hashToken(activation.Token),
+		ActivationExpiresAt: &activation.ExpiresAt,
+		ActivationSentAt:    &now,
 	}
-	token := m.GenerateSHA1Hash(ip)
-	user := User{Email: email, HashPassw: string(hashedPassword), ActivationToken: token}
 	result := m.db.WithContext(ctx).Create(&user)
 	if result.Error != nil {
-		return result.Error
+		return nil, result.Error
 	}
 
 	if result.RowsAffected == 0 {
-		return pkg.ErrNoRecord
+		return nil, pkg.ErrNoRecord
+	}
+
+	return activation, m.RecordEvent(ctx, Event{Action: ActionUserRegistered, UserID: user.ID})
+}
+
+// ResendActivation replaces the activation token of an inactive account.
+// Requests within activationResend of the previous email are refused with
+// ErrTooManyRequests; unknown or already active emails give ErrNoRecord.
+func (m *UserModelORM) ResendActivation(ctx context.Context, email string) (*ActivationToken, error) {
+	var user User
+	err := m.db.WithContext(ctx).Select("id", "active", "activation_sent_at").
+		Where("email = ?", strings.TrimSpace(email)).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, pkg.ErrNoRecord
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Active {
+		return nil, pkg.ErrNoRecord
+	}
+
+	now := time.Now()
+	if user.ActivationSentAt != nil && now.Sub(*user.ActivationSentAt) < m.activationResend {
+		return nil, pkg.ErrTooManyRequests
+	}
+
+	activation, err := m.newActivationToken()
+	if err != nil {
+		return nil, err
 	}
 
-	ip, _ = ctx.Value("ip_addr").(string)
+	return activation, m.db.WithContext(ctx).Model(&user).Updates(map[string]any{
+		"activation_token":      hashToken(activation.Token),
+		"activation_expires_at": activation.ExpiresAt,
+		"activation_sent_at":    now,
+	}).Error
+}
 
-	fmt.Println("print ip addr", ip)
-	activity := UserActivityLog{UserID: user.ID, Activity: "New User Register", IpAddr: ip}
-	return m.UserActivityLog(&activity)
+func (m *UserModelORM) newActivationToken() (*ActivationToken, error) {
+	token, err := generateRandomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ActivationToken{Token: token, ExpiresAt: time.Now().Add(m.activationTTL)}, nil
 }
 
-func (m *UserModelORM) LoginUser(c context.Context, creds *UserStruct) (string, error) {
+// LoginUser checks the password. Accounts with 2FA get an MFA token instead
+// of a session and finish the login in CompleteTwoFactorLogin.
+func (m *UserModelORM) LoginUser(c context.Context, creds *UserStruct) (*LoginResult, error) {
 	var user User
 	if err := m.db.WithContext(c).Where("email = ?", strings.TrimSpace(creds.Email)).First(&user).Error; err != nil {
-		m.logger.Error("Error fetching data", err)
-		return "", pkg.ErrInvalidCredentials
+		m.log(c).Error("Error fetching data", err)
+		return nil, pkg.ErrInvalidCredentials
 	}
 
 	if !user.Active {
-		return "", pkg.ErrAccountInActive
+		return nil, pkg.ErrAccountInActive
+	}
+
+	now := time.Now()
+	if blocked := m.loginBlocked(&user, now); blocked != nil {
+		reason := "delayed"
+		if blocked.Locked {
+			reason = "locked"
+		}
+		m.recordLoginFailure(c, user.ID, reason, nil)
+		return nil, blocked
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.HashPassw), []byte(creds.Password)); err != nil {
-		m.logger.Error("Error handling passw", err)
-		return "", pkg.ErrInvalidCredentials
+		m.log(c).Error("Error handling passw", err)
+		return nil, m.loginFailed(c, &user, now)
+	}
+
+	if err := m.clearLoginFailures(c, &user); err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		mfaToken, err := m.generateMFAToken(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFAToken: mfaToken}, nil
 	}
 
-	token, err := m.generateToken(user.Email, user.ID)
+	tokens, err := m.startSession(c, &user)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	ip, _ := c.Value("ip_addr").(string)
-	fmt.Println("print ip ", ip)
-	activity := UserActivityLog{UserID: user.ID, Activity: "Logged In", IpAddr: ip}
-	err = m.UserActivityLog(&activity)
+	return &LoginResult{Tokens: tokens}, nil
+}
+
+// startSession finishes a successful login.
+func (m *UserModelORM) startSession(c context.Context, user *User) (*AuthTokens, error) {
+	tokens, err := m.CreateSession(c, user)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	fmt.Println("print ip addr", ip)
-	return token, m.CreateSession(token, user.ID)
+	return tokens, m.RecordEvent(c, Event{Action: ActionLogin, UserID: user.ID})
 }
 
 func (m *UserModelORM) GeneratePassword(newPassword string) ([]byte, error) {
 	return bcrypt.GenerateFromPassword([]byte(newPassword), 12)
 }
 
-func (m *UserModelORM) CreateSession(token string, user_id uint) error {
-	user_session := UsersSession{UserID: user_id, LoginToken: token}
-	return m.db.Create(&user_session).Error
-}
 func (m *UserModelORM) ActivateAccount(ctx context.Context, token string) error {
 	var user User
-	if err := m.db.Select("id").Where("activation_token = ?", token).First(&user).Error; err != nil {
+	if err := m.db.Select("id", "activation_expires_at").Where("activation_token = ?", hashToken(token)).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return pkg.ErrNoRecord
 		}
 		return err
 	}
 
+	if user.ActivationExpiresAt == nil || time.Now().After(*user.ActivationExpiresAt) {
+		return pkg.ErrInvalidToken
+	}
+
 	result := m.db.Model(&user).Updates(map[string]any{
-		"activation_token": nil,
-		"active":           true,
-		"verified_at":      time.Now(),
+		"activation_token":      nil,
+		"activation_expires_at": nil,
+		"active":                true,
+		"verified_at":           time.Now(),
 	})
 
 	if result.Error != nil {
 		return result.Error
 	}
 
-	ip, _ := ctx.Value("ip_addr").(string)
-	fmt.Println("print ip addr", ip)
-	activity := UserActivityLog{UserID: user.ID, Activity: "Account Activated", IpAddr: ip}
-	return m.UserActivityLog(&activity)
+	return m.RecordEvent(ctx, Event{Action: ActionAccountActivated, UserID: user.ID})
 }
 
-func (m *UserModelORM) generateToken(email string, userID uint) (string, error) {
+func (m *UserModelORM) signingKey() ([]byte, error) {
 	if err := godotenv.Load(); err != nil {
 		m.logger.Error(err.Error())
-		return "", pkg.ErrInternalServer
+		return nil, pkg.ErrInternalServer
+	}
+
+	return []byte(os.Getenv("SIGNING_KEY")), nil
+}
+
+func (m *UserModelORM) generateToken(user *User, sessionID string, expiresAt time.Time) (string, error) {
+	signingKey, err := m.signingKey()
+	if err != nil {
+		return "", err
 	}
 
-	signingKey := []byte(os.Getenv("SIGNING_KEY"))
 	claims := MyCustomClaims{
-		Email:  email,
-		UserID: userID,
+		Email:     user.Email,
+		UserID:    user.ID,
+		SessionID: sessionID,
+		Role:      user.Role,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(4 * time.Hour).Unix(),
+			ExpiresAt: expiresAt.Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
 	}
@@ -138,16 +232,6 @@ func (m *UserModelORM) emailExists(email string) bool {
 	return count > 0
 }
 
-func (m *UserModelORM) GenerateSHA1Hash(ip string) string {
-	privateKey := "hello_world124#@##$$2"
-	rand.Seed(time.Now().UnixNano())
-	randomInt := rand.Intn(1000000)
-	input := fmt.Sprintf("%s:%d:%s", ip, randomInt, privateKey)
-	hash := sha1.New()
-	hash.Write([]byte(input))
-	return hex.EncodeToString(hash.Sum(nil))
-}
-
 func (m *UserModelORM) ValidateUserData(user *UserStruct, register bool) *pkg.Validator {
 	validator := &pkg.Validator{
 		Errors: make(map[string]string),
@@ -174,14 +258,3 @@ func (m *UserModelORM) ValidateUserData(user *UserStruct, register bool) *pkg.Va
 
 	return validator
 }
-
-func (m *UserModelORM) UserActivityLog(activity *UserActivityLog) error {
-	result := m.db.Model(&UserActivityLog{}).Where("user_id = ? AND activity =? AND superseded = 0", activity.UserID, activity.Activity).
-		Updates(map[string]any{"superseded": 1, "updated_at": time.Now()})
-
-	if result.Error != nil && result.Error != sql.ErrNoRows {
-		return result.Error
-	}
-
-	return m.db.Create(&activity).Error
-}
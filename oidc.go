@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// oidcProvider is one configured identity provider. Discovery happens on
+// first use, so the server starts even while the provider is unreachable.
+type oidcProvider struct {
+	Name        string
+	DisplayName string
+
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+
+	mu       sync.Mutex
+	config   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcStateClaims travel in a signed, short lived cookie between the redirect
+// to the provider and the callback, so no server side state is needed.
+type oidcStateClaims struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+// loadOIDCProviders reads OIDC_PROVIDERS, a comma separated list of names,
+// and for each name N the OIDC_<N>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optional _REDIRECT_URL, _SCOPES and _DISPLAY_NAME variables.
+func loadOIDCProviders(baseURL string) ([]*oidcProvider, error) {
+	var providers []*oidcProvider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		p := &oidcProvider{
+			Name:         name,
+			DisplayName:  pkg.EnvString(prefix+"DISPLAY_NAME", name),
+			issuer:       os.Getenv(prefix + "ISSUER"),
+			clientID:     os.Getenv(prefix + "CLIENT_ID"),
+			clientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			redirectURL:  pkg.EnvString(prefix+"REDIRECT_URL", baseURL+"/auth/oidc/"+name+"/callback"),
+			scopes:       strings.Fields(pkg.EnvString(prefix+"SCOPES", "openid email profile")),
+		}
+
+		if p.issuer == "" || p.clientID == "" {
+			return nil, fmt.Errorf("oidc provider %q needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.config != nil {
+		return p.config, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.config = &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		RedirectURL:  p.redirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.clientID})
+	return p.config, p.verifier, nil
+}
+
+func (app *Application) oidcProvider(name string) *oidcProvider {
+	for _, p := range app.OIDCProviders {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// OIDCLogin redirects to the provider's authorization endpoint using the
+// authorization code flow with PKCE.
+func (app *Application) OIDCLogin(c *gin.Context) {
+	provider := app.oidcProvider(c.Param("provider"))
+	if provider == nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	config, _, err := provider.discover(c.Request.Context())
+	if err != nil {
+		app.log(c).Error("OIDC discovery failed for ", provider.Name, ": ", err)
+		app.ErrorJSONResponse(c.Writer, http.StatusBadGateway, "Identity provider unavailable")
+		return
+	}
+
+	state := oauth2.GenerateVerifier()
+	nonce := oauth2.GenerateVerifier()
+	verifier := oauth2.GenerateVerifier()
+
+	cookie, err := app.signOIDCState(oidcStateClaims{
+		Provider: provider.Name,
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(oidcStateTTL).Unix(),
+		},
+	})
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	// Lax, not Strict: the callback is a cross site navigation from the
+	// provider and must still carry this cookie
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		HttpOnly: true,
+		Secure:   app.TLSEnabled,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	url := config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	c.Redirect(http.StatusFound, url)
+}
+
+// OIDCCallback finishes the flow: it checks state, exchanges the code with
+// the PKCE verifier, verifies the ID token and nonce, then logs the user in
+// exactly like a password login would.
+func (app *Application) OIDCCallback(c *gin.Context) {
+	provider := app.oidcProvider(c.Param("provider"))
+	if provider == nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		HttpOnly: true,
+		Secure:   app.TLSEnabled,
+		Path:     "/auth/oidc/",
+		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if errCode := c.Query("error"); errCode != "" {
+		app.log(c).Warn("OIDC provider ", provider.Name, " returned error: ", errCode, " ", c.Query("error_description"))
+		app.ErrorJSONResponse(c.Writer, http.StatusUnauthorized, "Login was cancelled or refused by the identity provider")
+		return
+	}
+
+	cookie, err := c.Request.Cookie(oidcStateCookie)
+	if err != nil {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Login session expired, please try again")
+		return
+	}
+
+	state, err := app.parseOIDCState(cookie.Value)
+	if err != nil || state.Provider != provider.Name ||
+		subtle.ConstantTimeCompare([]byte(state.State), []byte(c.Query("state"))) != 1 {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Invalid login state, please try again")
+		return
+	}
+
+	config, verifier, err := provider.discover(c.Request.Context())
+	if err != nil {
+		app.log(c).Error("OIDC discovery failed for ", provider.Name, ": ", err)
+		app.ErrorJSONResponse(c.Writer, http.StatusBadGateway, "Identity provider unavailable")
+		return
+	}
+
+	token, err := config.Exchange(c.Request.Context(), c.Query("code"), oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		app.log(c).Error("OIDC code exchange failed: ", err)
+		app.Metrics.login("oidc", false)
+		app.ErrorJSONResponse(c.Writer, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.ErrorJSONResponse(c.Writer, http.StatusUnauthorized, "Identity provider returned no id_token")
+		return
+	}
+
+	idToken, err := verifier.Verify(c.Request.Context(), rawIDToken)
+	if err != nil || subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+		app.log(c).Error("OIDC id_token rejected: ", err)
+		app.Metrics.login("oidc", false)
+		app.ErrorJSONResponse(c.Writer, http.StatusUnauthorized, "Login failed")
+		return
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified any    `json:"email_verified"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	result, err := app.Model.UsersORM.LoginWithIdentity(c.Request.Context(), models.ExternalIdentity{
+		Provider:      provider.Name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+	})
+	if err != nil {
+		app.log(c).Error("OIDC login failed: ", err)
+		switch {
+		case errors.Is(err, pkg.ErrEmailNotVerified), errors.Is(err, pkg.ErrAccountInActive):
+			app.Metrics.login("oidc", false)
+			app.ErrorJSONResponse(c.Writer, http.StatusForbidden, err.Error())
+		case errors.Is(err, pkg.ErrAccountLocked):
+			var blocked *models.LoginBlockedError
+			if errors.As(err, &blocked) {
+				app.retryAfter(c, time.Until(blocked.Until))
+			}
+			app.Metrics.login("oidc", false)
+			app.ErrorJSONResponse(c.Writer, http.StatusLocked, "Account locked after too many failed logins, see your email to unlock it")
+		default:
+			app.ServerError(c, err)
+		}
+		return
+	}
+
+	// with 2FA on the login page asks for the code and finishes at
+	// /login/2fa, as after a password
+	c.Header("Cache-Control", "no-store")
+	if result.MFAToken != "" {
+		otelgin.HTML(c, http.StatusOK, "login.html", gin.H{
+			"title":     "Login",
+			"Providers": app.OIDCProviders,
+			"CSPNonce":  cspNonce(c),
+			"MFAToken":  result.MFAToken,
+		})
+		return
+	}
+
+	app.Metrics.login("oidc", true)
+	app.setAuthCookies(c.Writer, result.Tokens)
+
+	// The SameSite=Strict session cookies are not sent on a redirect that
+	// started at the provider, so hop to the drive from a page of our own.
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(
+		`<!DOCTYPE html><html><head><meta http-equiv="refresh" content="0;url=/drive/"></head>`+
+			`<body>Signing you in&hellip; <a href="/drive/">Continue</a></body></html>`))
+}
+
+func (app *Application) signOIDCState(claims oidcStateClaims) (string, error) {
+	key := os.Getenv("SIGNING_KEY")
+	if key == "" {
+		return "", pkg.ErrNoEnvFileFound
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+}
+
+func (app *Application) parseOIDCState(value string) (*oidcStateClaims, error) {
+	key := os.Getenv("SIGNING_KEY")
+	if key == "" {
+		return nil, pkg.ErrNoEnvFileFound
+	}
+
+	token, err := jwt.ParseWithClaims(value, &oidcStateClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(key), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*oidcStateClaims)
+	if !ok || !token.Valid || claims.State == "" {
+		return nil, pkg.ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/iamgak/go-drive/models"
+	"gorm.io/gorm"
+)
+
+// mockIssuer is an OpenID provider serving discovery, its JWKS and a token
+// endpoint that checks PKCE and hands out RS256 signed id_tokens. Tests
+// play the user at the authorization endpoint by calling authorize.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+// mockGrant is what an authorization code stands for.
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss := &mockIssuer{key: key, codes: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                iss.URL,
+			"authorization_endpoint":                iss.URL + "/authorize",
+			"token_endpoint":                        iss.URL + "/token",
+			"jwks_uri":                              iss.URL + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", iss.token)
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+
+	iss.mu.Lock()
+	grant, ok := iss.codes[r.PostForm.Get("code")]
+	delete(iss.codes, r.PostForm.Get("code"))
+	iss.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(iss.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// authorize stands in for the user approving the login the authorization
+// URL asks for, returning the code. edit may change the id_token claims.
+func (iss *mockIssuer) authorize(t *testing.T, authURL *url.URL, subject, email string, edit func(jwt.MapClaims)) string {
+	t.Helper()
+	q := authURL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL without an S256 PKCE challenge: %s", authURL)
+	}
+
+	claims := jwt.MapClaims{
+		"iss":            iss.URL,
+		"sub":            subject,
+		"aud":            q.Get("client_id"),
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          q.Get("nonce"),
+		"email":          email,
+		"email_verified": true,
+	}
+	if edit != nil {
+		edit(claims)
+	}
+
+	code := "code-" + subject + "-" + q.Get("state")[:8]
+	iss.mu.Lock()
+	iss.codes[code] = mockGrant{challenge: q.Get("code_challenge"), claims: claims}
+	iss.mu.Unlock()
+	return code
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// oidcFlow is one login started at /auth/oidc/mock/login.
+type oidcFlow struct {
+	authURL     *url.URL
+	stateCookie *http.Cookie
+}
+
+func startOIDCLogin(t *testing.T, r http.Handler) oidcFlow {
+	t.Helper()
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body)
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := cookieNamed(w.Result().Cookies(), oidcStateCookie)
+	if state == nil {
+		t.Fatal("login set no state cookie")
+	}
+	return oidcFlow{authURL: authURL, stateCookie: state}
+}
+
+func oidcCallback(r http.Handler, code, state string, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	if stateCookie != nil {
+		req.AddCookie(stateCookie)
+	}
+	return serve(r, req)
+}
+
+func newOIDCTestApp(t *testing.T) (*Application, *gorm.DB, http.Handler, *mockIssuer) {
+	t.Helper()
+	app, db := newTestApp(t)
+	iss := newMockIssuer(t)
+	app.OIDCProviders = []*oidcProvider{{
+		Name:         "mock",
+		DisplayName:  "Mock",
+		issuer:       iss.URL,
+		clientID:     "go-drive",
+		clientSecret: "secret",
+		redirectURL:  "http://localhost/auth/oidc/mock/callback",
+		scopes:       []string{"openid", "email"},
+	}}
+	createTestUser(t, db, "user@example.com", "secret123")
+	return app, db, app.InitRouter(), iss
+}
+
+func TestOIDCLogin(t *testing.T) {
+	_, _, r, iss := newOIDCTestApp(t)
+
+	flow := startOIDCLogin(t, r)
+	if !strings.HasPrefix(flow.authURL.String(), iss.URL+"/authorize?") {
+		t.Fatalf("redirected to %s, want the issuer's authorization endpoint", flow.authURL)
+	}
+	q := flow.authURL.Query()
+	if q.Get("state") == "" || q.Get("nonce") == "" || q.Get("client_id") != "go-drive" {
+		t.Fatalf("authorization URL lacks state, nonce or client_id: %s", flow.authURL)
+	}
+
+	code := iss.authorize(t, flow.authURL, "sub-1", "user@example.com", nil)
+	w := oidcCallback(r, code, q.Get("state"), flow.stateCookie)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/drive/") {
+		t.Fatalf("callback: status %d: %s", w.Code, w.Body)
+	}
+
+	// the session cookie is the one a password login sets, and works alike
+	sso := cookieNamed(w.Result().Cookies(), accessCookie)
+	password := cookieNamed(passwordLogin(t, r, "user@example.com", "secret123"), accessCookie)
+	if sso == nil || password == nil {
+		t.Fatalf("%s cookie missing: sso %v, password %v", accessCookie, sso, password)
+	}
+	if sso.Path != password.Path || sso.HttpOnly != password.HttpOnly || sso.Secure != password.Secure ||
+		sso.SameSite != password.SameSite || abs(sso.MaxAge-password.MaxAge) > 1 {
+		t.Errorf("sso cookie %+v differs from the password login's %+v", sso, password)
+	}
+	if cookieNamed(w.Result().Cookies(), refreshCookie) == nil {
+		t.Errorf("sso login set no %s cookie", refreshCookie)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+	req.AddCookie(sso)
+	if w := serve(r, req); w.Code != http.StatusOK {
+		t.Fatalf("sso session rejected: status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	app, db, r, iss := newOIDCTestApp(t)
+
+	tests := []struct {
+		name   string
+		status int
+		// run finishes the flow the wrong way and returns the response
+		run func(flow oidcFlow) *httptest.ResponseRecorder
+	}{
+		{"state mismatch", http.StatusBadRequest, func(flow oidcFlow) *httptest.ResponseRecorder {
+			code := iss.authorize(t, flow.authURL, "sub-state", "user@example.com", nil)
+			return oidcCallback(r, code, "forged-state", flow.stateCookie)
+		}},
+		{"no state cookie", http.StatusBadRequest, func(flow oidcFlow) *httptest.ResponseRecorder {
+			code := iss.authorize(t, flow.authURL, "sub-cookie", "user@example.com", nil)
+			return oidcCallback(r, code, flow.authURL.Query().Get("state"), nil)
+		}},
+		{"wrong PKCE verifier", http.StatusUnauthorized, func(flow oidcFlow) *httptest.ResponseRecorder {
+			code := iss.authorize(t, flow.authURL, "sub-pkce", "user@example.com", nil)
+			// a validly signed state cookie, but with another verifier
+			state, err := app.parseOIDCState(flow.stateCookie.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			state.Verifier = strings.Repeat("x", 43)
+			forged, err := app.signOIDCState(*state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return oidcCallback(r, code, state.State, &http.Cookie{Name: oidcStateCookie, Value: forged})
+		}},
+		{"nonce mismatch", http.StatusUnauthorized, func(flow oidcFlow) *httptest.ResponseRecorder {
+			code := iss.authorize(t, flow.authURL, "sub-nonce", "user@example.com", func(c jwt.MapClaims) {
+				c["nonce"] = "replayed-nonce"
+			})
+			return oidcCallback(r, code, flow.authURL.Query().Get("state"), flow.stateCookie)
+		}},
+		{"id_token for another client", http.StatusUnauthorized, func(flow oidcFlow) *httptest.ResponseRecorder {
+			code := iss.authorize(t, flow.authURL, "sub-aud", "user@example.com", func(c jwt.MapClaims) {
+				c["aud"] = "someone-else"
+			})
+			return oidcCallback(r, code, flow.authURL.Query().Get("state"), flow.stateCookie)
+		}},
+		{"email not verified", http.StatusForbidden, func(flow oidcFlow) *httptest.ResponseRecorder {
+			code := iss.authorize(t, flow.authURL, "sub-unverified", "user@example.com", func(c jwt.MapClaims) {
+				c["email_verified"] = false
+			})
+			return oidcCallback(r, code, flow.authURL.Query().Get("state"), flow.stateCookie)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.run(startOIDCLogin(t, r))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if c := cookieNamed(w.Result().Cookies(), accessCookie); c != nil && c.Value != "" {
+				t.Fatalf("rejected login set %s", accessCookie)
+			}
+		})
+	}
+
+	// none of them linked an identity to the account
+	var links int64
+	if err := db.Model(&models.UserIdentity{}).Count(&links).Error; err != nil {
+		t.Fatal(err)
+	}
+	if links != 0 {
+		t.Fatalf("%d identities linked by rejected logins", links)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/pkg"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// forgotPasswordReply is sent whatever happened so the endpoint can't be
+// used to find out which emails are registered.
+const forgotPasswordReply = "If the email is registered, a password reset link has been sent"
+
+func (app *Application) ShowForgotPasswordPage(c *gin.Context) {
+	otelgin.HTML(c, http.StatusOK, "forgot_password.html", gin.H{
+		"title":    "Forgot Password",
+		"CSPNonce": cspNonce(c),
+	})
+}
+
+func (app *Application) ShowResetPasswordPage(c *gin.Context) {
+	token := c.Param("token")
+	valid, err := app.Model.UsersORM.ResetTokenValid(c.Request.Context(), token)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	otelgin.HTML(c, http.StatusOK, "reset_password.html", gin.H{
+		"title":    "Reset Password",
+		"Token":    token,
+		"Valid":    valid,
+		"CSPNonce": cspNonce(c),
+	})
+}
+
+func (app *Application) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Email == "" {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing email")
+		return
+	}
+
+	reset, err := app.Model.UsersORM.RequestPasswordReset(c.Request.Context(), req.Email)
+	switch err {
+	case nil:
+		app.sendMail(reset.Email, "Reset your Drive password", "password_reset", map[string]any{
+			"Link":      app.BaseURL + "/reset-password/" + reset.Token,
+			"ExpiresAt": reset.ExpiresAt,
+		})
+	case pkg.ErrNoRecord, pkg.ErrTooManyRequests:
+		app.log(c).Info("Password reset email skipped: ", err)
+	default:
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, forgotPasswordReply)
+}
+
+func (app *Application) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token          string `json:"token"`
+		Password       string `json:"password"`
+		RepeatPassword string `json:"repeatPassword"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Incorrect Input data provided")
+		return
+	}
+
+	validator := &pkg.Validator{Errors: make(map[string]string)}
+	validator.ValidPassword(req.Password)
+	validator.CheckField(req.Password == req.RepeatPassword, "repeatPassword", "Password not matched")
+	if !validator.Valid() {
+		c.JSON(http.StatusBadRequest, validator)
+		return
+	}
+
+	err := app.Model.UsersORM.ResetPassword(c.Request.Context(), req.Token, req.Password)
+	if err == pkg.ErrInvalidToken {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Reset link is invalid or has expired")
+		return
+	}
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.clearAuthCookies(c.Writer)
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Password changed, please login again")
+}
@@ -0,0 +1,126 @@
+// Package audit signs and stores checkpoints of the activity log's hash
+// chain. A checkpoint kept outside the database pins the chain head at a
+// point in time, so rewriting the whole chain, not just one row, is caught
+// as well.
+package audit
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var ErrBadSignature = errors.New("audit: checkpoint signature does not verify")
+
+// Checkpoint records the newest activity entry when it was taken.
+type Checkpoint struct {
+	EntryID   uint      `json:"entry_id"`
+	Hash      string    `json:"hash"`
+	Entries   int64     `json:"entries"`
+	CreatedAt time.Time `json:"created_at"`
+	Signature string    `json:"signature"`
+}
+
+// ParseKey reads a base64 encoded 32 byte Ed25519 seed, as printed by
+// GenerateKey.
+func ParseKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("audit: signing key must be %d base64 encoded bytes", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// GenerateKey returns a new base64 encoded seed for ParseKey.
+func GenerateKey() (string, error) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Seed()), nil
+}
+
+// message is what gets signed: every field except the signature.
+func (c Checkpoint) message() []byte {
+	return fmt.Appendf(nil, "go-drive audit checkpoint\n%d\n%s\n%d\n%s",
+		c.EntryID, c.Hash, c.Entries, c.CreatedAt.UTC().Format(time.RFC3339))
+}
+
+// Sign fills in c's signature.
+func (c *Checkpoint) Sign(key ed25519.PrivateKey) {
+	c.CreatedAt = c.CreatedAt.UTC().Truncate(time.Second)
+	c.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, c.message()))
+}
+
+// Verify checks c's signature against the public half of key.
+func (c Checkpoint) Verify(pub ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil || !ed25519.Verify(pub, c.message(), sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Append adds c as one JSON line to the file at path, creating it if needed.
+func Append(path string, c Checkpoint) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(c)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadFile returns the checkpoints in the file at path, oldest first. A
+// missing file has none.
+func ReadFile(path string) ([]Checkpoint, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var checkpoints []Checkpoint
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var c Checkpoint
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("audit: %s line %d: %w", path, line, err)
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, scanner.Err()
+}
+
+// Last returns the newest checkpoint in the file at path, or nil.
+func Last(path string) (*Checkpoint, error) {
+	checkpoints, err := ReadFile(path)
+	if err != nil || len(checkpoints) == 0 {
+		return nil, err
+	}
+	return &checkpoints[len(checkpoints)-1], nil
+}
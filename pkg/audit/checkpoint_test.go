@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParseKey(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestSignVerify(t *testing.T) {
+	key := testKey(t)
+	c := Checkpoint{EntryID: 42, Hash: strings.Repeat("ab", 32), Entries: 42, CreatedAt: time.Date(2026, 3, 4, 5, 6, 7, 890, time.FixedZone("X", 3600))}
+	c.Sign(key)
+
+	if err := c.Verify(key.Public().(ed25519.PublicKey)); err != nil {
+		t.Fatalf("Verify of a fresh checkpoint: %v", err)
+	}
+	if c.CreatedAt.Location() != time.UTC || c.CreatedAt.Nanosecond() != 0 {
+		t.Errorf("CreatedAt %v, want whole seconds in UTC", c.CreatedAt)
+	}
+
+	other := testKey(t)
+	if err := c.Verify(other.Public().(ed25519.PublicKey)); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Verify with another key = %v, want ErrBadSignature", err)
+	}
+
+	for name, edit := range map[string]func(*Checkpoint){
+		"entry id":   func(c *Checkpoint) { c.EntryID++ },
+		"hash":       func(c *Checkpoint) { c.Hash = strings.Repeat("cd", 32) },
+		"entries":    func(c *Checkpoint) { c.Entries-- },
+		"created at": func(c *Checkpoint) { c.CreatedAt = c.CreatedAt.Add(time.Second) },
+		"signature":  func(c *Checkpoint) { c.Signature = "not base64!" },
+	} {
+		changed := c
+		edit(&changed)
+		if err := changed.Verify(key.Public().(ed25519.PublicKey)); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("changed %s: Verify = %v, want ErrBadSignature", name, err)
+		}
+	}
+}
+
+func TestCheckpointFile(t *testing.T) {
+	key := testKey(t)
+	pub := key.Public().(ed25519.PublicKey)
+	path := filepath.Join(t.TempDir(), "checkpoints.jsonl")
+
+	if last, err := Last(path); err != nil || last != nil {
+		t.Fatalf("Last of a missing file = %v, %v", last, err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		c := Checkpoint{EntryID: uint(i * 10), Hash: strings.Repeat(string(rune('a'+i)), 64), Entries: int64(i * 10), CreatedAt: time.Now()}
+		c.Sign(key)
+		if err := Append(path, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkpoints, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 3 {
+		t.Fatalf("read %d checkpoints, want 3", len(checkpoints))
+	}
+	for _, c := range checkpoints {
+		if err := c.Verify(pub); err != nil {
+			t.Fatalf("checkpoint %d read back: %v", c.EntryID, err)
+		}
+	}
+	if last, err := Last(path); err != nil || last.EntryID != 30 {
+		t.Fatalf("Last = %+v, %v, want entry 30", last, err)
+	}
+
+	// edit the second line the way someone covering their tracks would
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(raw), "\n")
+	lines[1] = strings.Replace(lines[1], `"entries":20`, `"entries":19`, 1)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoints, err = ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range checkpoints {
+		err := c.Verify(pub)
+		if i == 1 && !errors.Is(err, ErrBadSignature) {
+			t.Errorf("edited line: Verify = %v, want ErrBadSignature", err)
+		}
+		if i != 1 && err != nil {
+			t.Errorf("untouched line %d: %v", i+1, err)
+		}
+	}
+
+	// a line that isn't JSON is reported with its number
+	if err := os.WriteFile(path, []byte(lines[0]+"\n{broken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("ReadFile of a broken line = %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for _, s := range []string{"", "not base64!", "c2hvcnQ="} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("ParseKey(%q) accepted it", s)
+		}
+	}
+}
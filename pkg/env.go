@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// EnvDuration reads a time.Duration such as "30s" from the environment,
+// falling back to def when unset or malformed.
+func EnvDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return def
+	}
+	return d
+}
+
+// EnvInt reads a non-negative integer from the environment, falling back to
+// def when unset or malformed.
+func EnvInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// EnvString reads key from the environment, falling back to def when unset.
+func EnvString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
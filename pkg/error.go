@@ -23,4 +23,20 @@ var (
 	ErrUserNotFound            = errors.New("errors: no such user exist")
 	ErrInvalidUserFound        = errors.New("errors: user access denied")
 	ErrInternalServer          = errors.New("errors: internal server error")
+	ErrPathEscape              = errors.New("errors: path escapes user directory")
+	ErrInvalidFilename         = errors.New("errors: invalid file name")
+	ErrInvalidToken            = errors.New("errors: invalid or expired token")
+	ErrSessionRevoked          = errors.New("errors: session has been revoked")
+	ErrRefreshTokenReused      = errors.New("errors: refresh token reused, session revoked")
+	ErrTooManyRequests         = errors.New("errors: too many requests, try again later")
+	ErrInvalidTwoFactorCode    = errors.New("errors: invalid two factor code")
+	ErrTwoFactorEnabled        = errors.New("errors: two factor authentication already enabled")
+	ErrTwoFactorNotEnabled     = errors.New("errors: two factor authentication not enabled")
+	ErrInvalidQuota            = errors.New("errors: quota can't be negative")
+	ErrQuotaExceeded           = errors.New("errors: storage quota exceeded")
+	ErrInvalidRole             = errors.New("errors: unknown role")
+	ErrInvalidScope            = errors.New("errors: unknown access token scope")
+	ErrInsufficientScope       = errors.New("errors: access token lacks the required scope")
+	ErrAccountLocked           = errors.New("errors: account locked after too many failed logins")
+	ErrEmailNotVerified        = errors.New("errors: identity provider did not verify the email")
 )
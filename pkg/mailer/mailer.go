@@ -0,0 +1,166 @@
+// Package mailer sends the application's transactional email, either over
+// SMTP (MailHog on localhost:1025 works for local testing) or, with the log
+// driver, by just writing the message to the application log.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+type Config struct {
+	Driver   string // "smtp" or "log"
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// New returns the mailer selected by cfg.Driver. There is no default: the
+// log driver writes the activation, reset and unlock links into the log, so
+// it has to be asked for by name.
+func New(cfg Config, logger *logrus.Logger) (Mailer, error) {
+	switch cfg.Driver {
+	case "":
+		return nil, fmt.Errorf("mailer: no driver set, use smtp, or log to write emails and their links to the log")
+	case "log":
+		return &LogMailer{Logger: logger, From: cfg.From}, nil
+	case "smtp":
+		if cfg.Host == "" || cfg.From == "" {
+			return nil, fmt.Errorf("mailer: smtp driver needs MAIL_HOST and MAIL_FROM")
+		}
+		if cfg.Port == "" {
+			cfg.Port = "25"
+		}
+		return &SMTPMailer{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("mailer: unknown driver %q", cfg.Driver)
+	}
+}
+
+type SMTPMailer struct {
+	cfg Config
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	body, err := buildMIME(m.cfg.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	// net/smtp has no context support, so the send runs in the background and
+	// the caller stops waiting once ctx is done
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(m.cfg.Host, m.cfg.Port), auth, m.cfg.From, []string{msg.To}, body)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer writes every message, body and links included, to the log.
+// It's meant for development only.
+type LogMailer struct {
+	Logger *logrus.Logger
+	From   string
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	m.Logger.WithFields(logrus.Fields{
+		"to":      msg.To,
+		"from":    m.From,
+		"subject": msg.Subject,
+	}).Info("Mail (log driver):\n", msg.Text)
+	return nil
+}
+
+// buildMIME renders msg as a multipart/alternative email with a plain text
+// and an HTML part.
+func buildMIME(from string, msg Message) ([]byte, error) {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return nil, fmt.Errorf("mailer: header injection attempt")
+	}
+
+	boundary, err := randomBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		if part.body == "" {
+			continue
+		}
+
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\r\n")
+	}
+
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
+
+func randomBoundary() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,277 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNew(t *testing.T) {
+	logger := logrus.New()
+	tests := []struct {
+		cfg     Config
+		wantErr bool
+	}{
+		{Config{}, true},
+		{Config{Driver: "log"}, false},
+		{Config{Driver: "smtp", Host: "127.0.0.1", From: "drive@localhost"}, false},
+		{Config{Driver: "smtp", From: "drive@localhost"}, true},
+		{Config{Driver: "smtp", Host: "127.0.0.1"}, true},
+		{Config{Driver: "sendmail"}, true},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.cfg, logger)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("New(%+v) error = %v, want error %t", tt.cfg, err, tt.wantErr)
+		}
+	}
+
+	m, err := New(Config{Driver: "smtp", Host: "127.0.0.1", From: "drive@localhost"}, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := m.(*SMTPMailer).cfg.Port; port != "25" {
+		t.Errorf("default port %q, want 25", port)
+	}
+}
+
+func TestBuildMIME(t *testing.T) {
+	msg := Message{
+		To:      "user@example.com",
+		Subject: "Réinitialiser le mot de passe",
+		Text:    "Open https://drive.example/reset-password/abc?x=1 — it expires soon.",
+		HTML:    `<p><a href="https://drive.example/reset-password/abc?x=1">Reset</a> — it expires soon.</p>`,
+	}
+	raw, err := buildMIME("drive@example.com", msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Header.Get("From"); got != "drive@example.com" {
+		t.Errorf("From %q", got)
+	}
+	if got := parsed.Header.Get("To"); got != msg.To {
+		t.Errorf("To %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("Subject %q (%v), want %q", subject, err, msg.Subject)
+	}
+	if _, err := parsed.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+
+	parts := readParts(t, parsed)
+	if len(parts) != 2 || parts["text/plain"] != msg.Text || parts["text/html"] != msg.HTML {
+		t.Fatalf("parts %q, want the text and HTML bodies", parts)
+	}
+
+	// an empty part is left out
+	raw, err = buildMIME("drive@example.com", Message{To: msg.To, Subject: "Hi", Text: "only text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err = mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parts := readParts(t, parsed); len(parts) != 1 || parts["text/plain"] != "only text" {
+		t.Fatalf("parts %q, want only the text part", parts)
+	}
+}
+
+func TestBuildMIMERejectsHeaderInjection(t *testing.T) {
+	for _, msg := range []Message{
+		{To: "user@example.com\r\nBcc: victim@example.com", Subject: "Hi"},
+		{To: "user@example.com\nBcc: victim@example.com", Subject: "Hi"},
+		{To: "user@example.com\r", Subject: "Hi"},
+		{To: "user@example.com", Subject: "Hi\r\nBcc: victim@example.com"},
+		{To: "user@example.com", Subject: "Hi\n\n<html>forged body"},
+	} {
+		if raw, err := buildMIME("drive@example.com", msg); err == nil {
+			t.Errorf("buildMIME(%q, %q) accepted it:\n%s", msg.To, msg.Subject, raw)
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	server := newFakeSMTP(t)
+	host, port, _ := net.SplitHostPort(server.addr)
+	m, err := New(Config{Driver: "smtp", Host: host, Port: port, From: "drive@example.com"}, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := Message{To: "user@example.com", Subject: "Activate", Text: "Open the link", HTML: "<p>Open the link</p>"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-server.received
+	if got.from != "drive@example.com" || len(got.to) != 1 || got.to[0] != "user@example.com" {
+		t.Fatalf("envelope from %q to %q", got.from, got.to)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Header.Get("Subject") != "Activate" {
+		t.Errorf("Subject %q", parsed.Header.Get("Subject"))
+	}
+	if parts := readParts(t, parsed); parts["text/plain"] != msg.Text || parts["text/html"] != msg.HTML {
+		t.Errorf("parts %q", parts)
+	}
+
+	// the message is refused before anything is sent
+	msg.To = "user@example.com\r\nBcc: victim@example.com"
+	if err := m.Send(context.Background(), msg); err == nil {
+		t.Error("Send accepted a header injection")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Send(ctx, Message{To: "user@example.com", Subject: "Hi", Text: "x"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Send with a cancelled context = %v", err)
+	}
+}
+
+// Sends through MailHog, or any SMTP server, at MAILHOG_ADDR when it's set,
+// e.g. MAILHOG_ADDR=127.0.0.1:1025.
+func TestSMTPMailerSendMailHog(t *testing.T) {
+	addr := os.Getenv("MAILHOG_ADDR")
+	if addr == "" {
+		t.Skip("MAILHOG_ADDR not set")
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(Config{Driver: "smtp", Host: host, Port: port, From: "drive@localhost"}, logrus.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), Message{To: "user@example.com", Subject: "go-drive mailer test", Text: "plain", HTML: "<p>html</p>"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readParts returns the decoded bodies of a multipart message by type.
+func readParts(t *testing.T, msg *mail.Message) map[string]string {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type %q (%v)", msg.Header.Get("Content-Type"), err)
+	}
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// multipart decodes quoted-printable itself
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = strings.TrimSuffix(string(body), "\r\n")
+	}
+}
+
+// fakeSMTP is just enough of an SMTP server for net/smtp.SendMail.
+type fakeSMTP struct {
+	addr     string
+	received chan fakeMail
+}
+
+type fakeMail struct {
+	from string
+	to   []string
+	data string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{addr: ln.Addr().String(), received: make(chan fakeMail, 1)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.session(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	var m fakeMail
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 fake")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			m.from = strings.Trim(strings.TrimSpace(line)[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			m.to = append(m.to, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			m.data = data.String()
+			s.received <- m
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
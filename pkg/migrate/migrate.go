@@ -0,0 +1,499 @@
+// Package migrate applies numbered SQL migrations and records them in the
+// schema_migrations table. A migration is a pair of files,
+// NNNN_name.up.sql and NNNN_name.down.sql, numbered from 1 without gaps;
+// statements in them end with a semicolon.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	fileName  = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+	validName = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+const (
+	// lockName and lockKey name the lock that keeps two instances starting
+	// together from running the same migration, on MySQL and Postgres.
+	lockName    = "go-drive-migrate"
+	lockKey     = 7061626
+	lockTimeout = 60 // seconds
+)
+
+var ErrLockTimeout = errors.New("migrate: timed out waiting for another instance's migrations")
+
+// Migration is one numbered change to the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status is a migration and when it was applied, nil while pending.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	// Missing is set for a migration the database has but this build
+	// doesn't, applied by a newer one.
+	Missing bool
+}
+
+type schemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Load reads the migrations in the top directory of fsys, oldest first.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		match := fileName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s is not named NNNN_name.up.sql or NNNN_name.down.sql", e.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		if version == 0 {
+			return nil, fmt.Errorf("%s: versions start at 1", e.Name())
+		}
+		// 1_a.up.sql and 0001_a.up.sql are the same migration twice
+		half := strconv.Itoa(version) + "." + match[3]
+		if seen[half] {
+			return nil, fmt.Errorf("%s: there is another %s file for version %d", e.Name(), match[3], version)
+		}
+		seen[half] = true
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != match[2] {
+			return nil, fmt.Errorf("version %d is used by both %s and %s", version, mig.Name, match[2])
+		}
+		if match[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for version, mig := range byVersion {
+		if !seen[strconv.Itoa(version)+".up"] || !seen[strconv.Itoa(version)+".down"] {
+			return nil, fmt.Errorf("%s needs both an up and a down file", mig)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	// a gap is usually a migration lost in a merge; running past it would
+	// record the schema as further along than it is
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			return nil, fmt.Errorf("migration %04d is missing before %s", i+1, mig)
+		}
+	}
+	return migrations, nil
+}
+
+// Migrator runs one set of migrations against a database.
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New loads the migrations in fsys for db.
+func New(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest is the newest migration's version, 0 when there are none.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Initialized reports whether the database has a schema_migrations table.
+func (m *Migrator) Initialized(ctx context.Context) bool {
+	return m.db.WithContext(ctx).Migrator().HasTable(&schemaMigration{})
+}
+
+// Status lists every migration, this build's and any the database has
+// recorded that it doesn't know, oldest first.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Status
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if row, ok := applied[mig.Version]; ok {
+			s.AppliedAt = &row.AppliedAt
+			delete(applied, mig.Version)
+		}
+		list = append(list, s)
+	}
+	for _, row := range applied {
+		list = append(list, Status{
+			Migration: Migration{Version: row.Version, Name: row.Name},
+			AppliedAt: &row.AppliedAt,
+			Missing:   true,
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// Pending returns the migrations not applied yet, oldest first.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(m.db.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration, oldest first, each in its own
+// transaction, and returns the ones it applied. MySQL commits DDL as it
+// goes, so there a migration that fails halfway has to be cleaned up by
+// hand before it's run again.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		for _, mig := range m.migrations {
+			ran, err := m.apply(conn, mig)
+			if err != nil {
+				return fmt.Errorf("migration %s: %w", mig, err)
+			}
+			if ran {
+				done = append(done, mig)
+			}
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down rolls back the n most recently applied migrations, newest first,
+// and returns them.
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *gorm.DB) error {
+		var rows []schemaMigration
+		if err := conn.Order("version DESC").Limit(n).Find(&rows).Error; err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			mig, ok := m.find(row.Version)
+			if !ok {
+				return fmt.Errorf("migration %04d_%s was applied by a newer build, roll it back with that one", row.Version, row.Name)
+			}
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := execScript(tx, mig.Down); err != nil {
+					return err
+				}
+				return tx.Delete(&schemaMigration{}, "version = ?", mig.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %s: %w", mig, err)
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Baseline records the migrations up to version as applied without running
+// them, for a database whose schema was made some other way.
+func (m *Migrator) Baseline(ctx context.Context, version int) error {
+	return m.locked(ctx, func(conn *gorm.DB) error {
+		applied, err := m.applied(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok || mig.Version > version {
+				continue
+			}
+			row := schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now().UTC()}
+			if err := conn.Create(&row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// apply runs mig unless it was applied already, possibly by another
+// instance on SQLite, where there's no lock but a transaction holds the
+// write lock from the start.
+func (m *Migrator) apply(conn *gorm.DB, mig Migration) (bool, error) {
+	ran := false
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&schemaMigration{}).Where("version = ?", mig.Version).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+
+		if err := execScript(tx, mig.Up); err != nil {
+			return err
+		}
+		ran = true
+		return tx.Create(&schemaMigration{Version: mig.Version, Name: mig.Name, AppliedAt: time.Now().UTC()}).Error
+	})
+	return ran, err
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, mig := range m.migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+// applied returns the recorded migrations by version, none before the
+// table exists.
+func (m *Migrator) applied(db *gorm.DB) (map[int]schemaMigration, error) {
+	applied := map[int]schemaMigration{}
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return applied, nil
+	}
+
+	var rows []schemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// locked runs fn on one connection holding the migration lock, with the
+// schema_migrations table in place.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		// Connection hands over a handle whose statement every call shares,
+		// a session makes each call start from a clean one again
+		conn = conn.Session(&gorm.Session{})
+		unlock, err := lock(conn)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if !conn.Migrator().HasTable(&schemaMigration{}) {
+			if err := conn.Migrator().CreateTable(&schemaMigration{}); err != nil {
+				return err
+			}
+		}
+		return fn(conn)
+	})
+}
+
+// lock takes a session lock on conn. SQLite has none to take and relies on
+// its write lock instead, see apply.
+func lock(conn *gorm.DB) (func(), error) {
+	switch conn.Dialector.Name() {
+	case "postgres":
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error; err != nil {
+			return nil, err
+		}
+		return func() { conn.Exec("SELECT pg_advisory_unlock(?)", lockKey) }, nil
+
+	case "mysql":
+		var got sql.NullInt64
+		if err := conn.Raw("SELECT GET_LOCK(?, ?)", lockName, lockTimeout).Scan(&got).Error; err != nil {
+			return nil, err
+		}
+		if got.Int64 != 1 {
+			return nil, ErrLockTimeout
+		}
+		return func() { conn.Exec("SELECT RELEASE_LOCK(?)", lockName) }, nil
+	}
+	return func() {}, nil
+}
+
+func execScript(tx *gorm.DB, script string) error {
+	for _, stmt := range statements(script) {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statements splits a migration file at the semicolons that end its
+// statements, not those inside quotes, PostgreSQL dollar quotes or
+// comments. A quote inside a string is written doubled. Comments are
+// dropped; drivers don't all take several statements in one Exec.
+func statements(script string) []string {
+	var stmts []string
+	var cur strings.Builder
+	flush := func() {
+		if stmt := strings.TrimSpace(cur.String()); stmt != "" && stmt != ";" {
+			stmts = append(stmts, stmt)
+		}
+		cur.Reset()
+	}
+
+	for i := 0; i < len(script); i++ {
+		rest := script[i:]
+		switch {
+		case strings.HasPrefix(rest, "--"):
+			// up to the newline, which is kept
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			i += end - 1
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				end = len(rest)
+			} else {
+				end += 4
+			}
+			cur.WriteByte(' ')
+			i += end - 1
+		case rest[0] == '\'' || rest[0] == '"' || rest[0] == '`':
+			end := quoted(rest, string(rest[0]))
+			cur.WriteString(rest[:end])
+			i += end - 1
+		case rest[0] == '$' && dollarTag.MatchString(rest):
+			tag := dollarTag.FindString(rest)
+			end := quoted(rest[len(tag)-1:], tag) + len(tag) - 1
+			cur.WriteString(rest[:end])
+			i += end - 1
+		case rest[0] == ';':
+			cur.WriteByte(';')
+			flush()
+		default:
+			cur.WriteByte(rest[0])
+		}
+	}
+	flush()
+	return stmts
+}
+
+// dollarTag matches the opening of a PostgreSQL dollar quoted string, $$ or
+// $tag$.
+var dollarTag = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+
+// quoted returns the length of the quoted string s starts with, closed by
+// the first lone close; a doubled close stands for itself. An unclosed
+// string runs to the end of s.
+func quoted(s, close string) int {
+	for i := 1; i < len(s); {
+		end := strings.Index(s[i:], close)
+		if end < 0 {
+			break
+		}
+		i += end + len(close)
+		if len(close) == 1 && strings.HasPrefix(s[i:], close) {
+			i += len(close)
+			continue
+		}
+		return i
+	}
+	return len(s)
+}
+
+// Create writes the up and down files of a new migration called name into
+// each of dirs, numbered after the newest migration in any of them, and
+// returns their paths.
+func Create(dirs []string, name string) ([]string, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("name %q may only have lower case letters, digits and underscores", name)
+	}
+
+	latest := 0
+	for _, dir := range dirs {
+		migrations, err := Load(os.DirFS(dir))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		for _, mig := range migrations {
+			latest = max(latest, mig.Version)
+		}
+	}
+
+	base := Migration{Version: latest + 1, Name: name}.String()
+	var paths []string
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return paths, err
+		}
+		for _, direction := range []string{"up", "down"} {
+			path := filepath.Join(dir, base+"."+direction+".sql")
+			header := fmt.Sprintf("-- %s %s: end each statement with a semicolon.\n", base, direction)
+			if err := os.WriteFile(path, []byte(header), 0644); err != nil {
+				return paths, err
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,327 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// files returns a MapFS holding the named files, each with body.
+func files(body string, names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys[name] = &fstest.MapFile{Data: []byte(body)}
+	}
+	return fsys
+}
+
+func TestLoad(t *testing.T) {
+	fsys := files("SELECT 1;", "0002_b.up.sql", "0002_b.down.sql", "0001_a.up.sql", "0001_a.down.sql", "README.md")
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].String() != "0001_a" || migrations[1].String() != "0002_b" {
+		t.Fatalf("Load = %v, want 0001_a, 0002_b", migrations)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		fsys  fstest.MapFS
+		error string
+	}{
+		{"gap", files("", "0001_a.up.sql", "0001_a.down.sql", "0003_c.up.sql", "0003_c.down.sql"), "0002 is missing"},
+		{"no first", files("", "0002_b.up.sql", "0002_b.down.sql"), "0001 is missing"},
+		{"version zero", files("", "0000_a.up.sql", "0000_a.down.sql"), "start at 1"},
+		{"duplicate version", files("", "0001_a.up.sql", "0001_a.down.sql", "0001_b.up.sql", "0001_b.down.sql"), "another down file for version 1"},
+		{"same version written twice", files("", "0001_a.up.sql", "0001_a.down.sql", "1_a.up.sql", "1_a.down.sql"), "another down file for version 1"},
+		{"missing down", files("", "0001_a.up.sql", "0001_a.down.sql", "0002_b.up.sql"), "0002_b needs both"},
+		{"missing up", files("", "0001_a.down.sql"), "0001_a needs both"},
+		{"bad name", files("", "0001_A.up.sql"), "0001_A.up.sql"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.fsys)
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Fatalf("Load error = %v, want one containing %q", err, tt.error)
+			}
+		})
+	}
+}
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{
+			"one per line",
+			"CREATE TABLE a (id INT);\nCREATE TABLE b (id INT);\n",
+			[]string{"CREATE TABLE a (id INT);", "CREATE TABLE b (id INT);"},
+		},
+		{
+			"several lines",
+			"CREATE TABLE a (\n  id INT\n);",
+			[]string{"CREATE TABLE a (\n  id INT\n);"},
+		},
+		{
+			"no final semicolon",
+			"SELECT 1; SELECT 2",
+			[]string{"SELECT 1;", "SELECT 2"},
+		},
+		{
+			"single quotes",
+			"INSERT INTO a VALUES ('x;y'); INSERT INTO a VALUES ('it''s; fine');",
+			[]string{"INSERT INTO a VALUES ('x;y');", "INSERT INTO a VALUES ('it''s; fine');"},
+		},
+		{
+			"double quotes and backticks",
+			"CREATE TABLE \"a;b\" (id INT); CREATE TABLE `c;d` (id INT);",
+			[]string{"CREATE TABLE \"a;b\" (id INT);", "CREATE TABLE `c;d` (id INT);"},
+		},
+		{
+			"line comments",
+			"-- creates a; and b\nCREATE TABLE a (id INT); -- a; done\n-- only a comment;\n",
+			[]string{"CREATE TABLE a (id INT);"},
+		},
+		{
+			"block comments",
+			"/* a;\n b; */ CREATE TABLE a (id /* ; */ INT);",
+			[]string{"CREATE TABLE a (id   INT);"},
+		},
+		{
+			"comment marks in a string",
+			"INSERT INTO a VALUES ('-- not; a comment', '/* nor; this */');",
+			[]string{"INSERT INTO a VALUES ('-- not; a comment', '/* nor; this */');"},
+		},
+		{
+			"dollar quotes",
+			"CREATE FUNCTION f() RETURNS INT AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql;\nSELECT $fn$ a;b $fn$;",
+			[]string{"CREATE FUNCTION f() RETURNS INT AS $$ BEGIN RETURN 1; END; $$ LANGUAGE plpgsql;", "SELECT $fn$ a;b $fn$;"},
+		},
+		{
+			"empty statements",
+			";;\n  ;\n",
+			nil,
+		},
+		{
+			"unclosed quote",
+			"SELECT 'a; b",
+			[]string{"SELECT 'a; b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statements(tt.script); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("statements(%q)\n got %q\nwant %q", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+var testMigrations = fstest.MapFS{
+	"0001_notes.up.sql": {Data: []byte(`
+-- notes; the first table
+CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL DEFAULT 'a; b');
+INSERT INTO notes (body) VALUES ('first; note');
+`)},
+	"0001_notes.down.sql": {Data: []byte("DROP TABLE notes;\n")},
+	"0002_tags.up.sql": {Data: []byte(`
+CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT NOT NULL);
+CREATE INDEX idx_tags_name ON tags (name);
+`)},
+	"0002_tags.down.sql": {Data: []byte("DROP INDEX idx_tags_name;\nDROP TABLE tags;\n")},
+}
+
+func versions(migrations []Migration) []int {
+	var list []int
+	for _, mig := range migrations {
+		list = append(list, mig.Version)
+	}
+	return list
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	m, err := New(db, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Initialized(ctx) {
+		t.Fatal("Initialized before anything ran")
+	}
+	if m.Latest() != 2 {
+		t.Fatalf("Latest = %d, want 2", m.Latest())
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil || !reflect.DeepEqual(versions(pending), []int{1, 2}) {
+		t.Fatalf("Pending = %v, %v, want 1, 2", pending, err)
+	}
+
+	done, err := m.Up(ctx)
+	if err != nil || !reflect.DeepEqual(versions(done), []int{1, 2}) {
+		t.Fatalf("Up = %v, %v, want 1, 2", done, err)
+	}
+	var body string
+	if err := db.Raw("SELECT body FROM notes").Scan(&body).Error; err != nil || body != "first; note" {
+		t.Fatalf("notes body %q, %v", body, err)
+	}
+	if !db.Migrator().HasTable("tags") || !db.Migrator().HasIndex("tags", "idx_tags_name") {
+		t.Fatal("0002 didn't create tags and its index")
+	}
+	if pending, err := m.Pending(ctx); err != nil || len(pending) != 0 {
+		t.Fatalf("Pending after Up = %v, %v", pending, err)
+	}
+
+	// a second Up has nothing to do
+	if done, err := m.Up(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("second Up = %v, %v", done, err)
+	}
+
+	done, err = m.Down(ctx, 1)
+	if err != nil || !reflect.DeepEqual(versions(done), []int{2}) {
+		t.Fatalf("Down(1) = %v, %v, want 2", done, err)
+	}
+	if db.Migrator().HasTable("tags") || !db.Migrator().HasTable("notes") {
+		t.Fatal("Down(1) didn't roll back only 0002")
+	}
+	status, err := m.Status(ctx)
+	if err != nil || len(status) != 2 || status[0].AppliedAt == nil || status[1].AppliedAt != nil {
+		t.Fatalf("Status after Down(1) = %+v, %v", status, err)
+	}
+
+	if done, err := m.Up(ctx); err != nil || !reflect.DeepEqual(versions(done), []int{2}) {
+		t.Fatalf("Up after Down(1) = %v, %v, want 2", done, err)
+	}
+	done, err = m.Down(ctx, 5)
+	if err != nil || !reflect.DeepEqual(versions(done), []int{2, 1}) {
+		t.Fatalf("Down(5) = %v, %v, want 2, 1", done, err)
+	}
+	if db.Migrator().HasTable("notes") || db.Migrator().HasTable("tags") {
+		t.Fatal("Down(5) left tables behind")
+	}
+	if pending, err := m.Pending(ctx); err != nil || len(pending) != 2 {
+		t.Fatalf("Pending after Down(5) = %v, %v", pending, err)
+	}
+}
+
+func TestUpStopsAtFailure(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	fsys := fstest.MapFS{
+		"0001_notes.up.sql":   testMigrations["0001_notes.up.sql"],
+		"0001_notes.down.sql": testMigrations["0001_notes.down.sql"],
+		"0002_bad.up.sql":     {Data: []byte("CREATE TABLE tags (id INTEGER);\nCREATE TABLE nonsense (;\n")},
+		"0002_bad.down.sql":   {Data: []byte("DROP TABLE tags;\n")},
+	}
+	m, err := New(db, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := m.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "0002_bad") {
+		t.Fatalf("Up error = %v, want one naming 0002_bad", err)
+	}
+	if !reflect.DeepEqual(versions(done), []int{1}) {
+		t.Fatalf("Up applied %v, want 1", done)
+	}
+	// SQLite rolls the DDL back with the rest of the transaction
+	if db.Migrator().HasTable("tags") {
+		t.Error("the failed migration's first statement was kept")
+	}
+	if pending, err := m.Pending(ctx); err != nil || !reflect.DeepEqual(versions(pending), []int{2}) {
+		t.Fatalf("Pending = %v, %v, want 2", pending, err)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	// the schema 0001 would make, made some other way
+	if err := db.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT NOT NULL)").Error; err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(db, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Baseline(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Initialized(ctx) {
+		t.Fatal("not Initialized after Baseline")
+	}
+	done, err := m.Up(ctx)
+	if err != nil || !reflect.DeepEqual(versions(done), []int{2}) {
+		t.Fatalf("Up after Baseline = %v, %v, want only 2", done, err)
+	}
+	var count int64
+	if err := db.Table("notes").Count(&count).Error; err != nil || count != 0 {
+		t.Fatalf("0001 ran after all: %d notes, %v", count, err)
+	}
+
+	// baselining again changes nothing
+	if err := m.Baseline(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	status, err := m.Status(ctx)
+	if err != nil || len(status) != 2 || status[0].AppliedAt == nil || status[1].AppliedAt == nil {
+		t.Fatalf("Status = %+v, %v", status, err)
+	}
+}
+
+func TestStatusMissing(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t)
+	newer, err := New(db, testMigrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newer.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// an older build that only knows 0001
+	older, err := New(db, fstest.MapFS{
+		"0001_notes.up.sql":   testMigrations["0001_notes.up.sql"],
+		"0001_notes.down.sql": testMigrations["0001_notes.down.sql"],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := older.Status(ctx)
+	if err != nil || len(status) != 2 || !status[1].Missing || status[1].Name != "tags" {
+		t.Fatalf("Status = %+v, %v, want 0002_tags missing", status, err)
+	}
+	if _, err := older.Down(ctx, 1); err == nil || !strings.Contains(err.Error(), "newer build") {
+		t.Fatalf("Down error = %v, want the newer build's migration refused", err)
+	}
+}
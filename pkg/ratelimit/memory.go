@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops closed windows.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the counters in this process.
+type MemoryStore struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type window struct {
+	count   int64
+	resetAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{windows: make(map[string]*window), lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) Peek(_ context.Context, key string) (int64, time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.windows[key]
+	if !ok || now.After(w.resetAt) {
+		return 0, 0, nil
+	}
+	return w.count, w.resetAt.Sub(now), nil
+}
+
+func (s *MemoryStore) Increment(_ context.Context, key string, length time.Duration) (int64, time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// sweeping as part of a hit saves a goroutine that would outlive the store
+	if now.Sub(s.lastSweep) > sweepInterval {
+		for k, w := range s.windows {
+			if now.After(w.resetAt) {
+				delete(s.windows, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	w, ok := s.windows[key]
+	if !ok || now.After(w.resetAt) {
+		w = &window{resetAt: now.Add(length)}
+		s.windows[key] = w
+	}
+	w.count++
+	return w.count, w.resetAt.Sub(now), nil
+}
@@ -0,0 +1,89 @@
+// Package ratelimit counts requests in fixed windows. The counters live in a
+// Store, in memory for a single instance or in Redis when several instances
+// have to share them.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Window.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// ParseLimit reads limits written like "30/1m" or "5/1s".
+func ParseLimit(s string) (Limit, error) {
+	n, window, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("ratelimit: %q is not <requests>/<window>", s)
+	}
+
+	requests, err := strconv.Atoi(n)
+	if err != nil || requests < 1 {
+		return Limit{}, fmt.Errorf("ratelimit: bad request count in %q", s)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: bad window in %q", s)
+	}
+	return Limit{Requests: requests, Window: d}, nil
+}
+
+func (l Limit) String() string {
+	return strconv.Itoa(l.Requests) + "/" + l.Window.String()
+}
+
+// Store counts hits per key. Increment adds one to key's counter, starting
+// a new window of the given length when none is open, and returns the count
+// and the time left until the window closes. Peek returns the same without
+// counting a hit; a key with no open window has a count of 0.
+type Store interface {
+	Increment(ctx context.Context, key string, window time.Duration) (count int64, reset time.Duration, err error)
+	Peek(ctx context.Context, key string) (count int64, reset time.Duration, err error)
+}
+
+// Result is the state of a key after a hit.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
+// Exceeded reports whether key already used up limit, without counting a
+// hit. It's for limits on outcomes, e.g. failed logins, that are checked
+// before the attempt and only counted after it.
+func Exceeded(ctx context.Context, store Store, key string, limit Limit) (Result, error) {
+	count, reset, err := store.Peek(ctx, key)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:   count < int64(limit.Requests),
+		Limit:     limit.Requests,
+		Remaining: max(limit.Requests-int(count), 0),
+		Reset:     reset,
+	}, nil
+}
+
+// Allow records a hit on key and reports whether it is within limit.
+func Allow(ctx context.Context, store Store, key string, limit Limit) (Result, error) {
+	count, reset, err := store.Increment(ctx, key, limit.Window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:   count <= int64(limit.Requests),
+		Limit:     limit.Requests,
+		Remaining: max(limit.Requests-int(count), 0),
+		Reset:     reset,
+	}, nil
+}
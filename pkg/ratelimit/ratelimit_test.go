@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// stores runs fn against a MemoryStore, and against a RedisStore too when
+// REDIS_URL points at a server, e.g.
+//
+//	REDIS_URL=redis://localhost:6379/15 go test ./pkg/ratelimit
+func stores(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryStore())
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		url := os.Getenv("REDIS_URL")
+		if url == "" {
+			t.Skip("REDIS_URL not set")
+		}
+		opts, err := redis.ParseURL(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := redis.NewClient(opts)
+		t.Cleanup(func() { client.Close() })
+
+		// a prefix of its own keeps runs apart and tells cleanup what to drop
+		prefix := "go-drive:ratelimit-test:" + strconv.FormatInt(time.Now().UnixNano(), 36) + ":"
+		t.Cleanup(func() {
+			ctx := context.Background()
+			keys, _ := client.Keys(ctx, prefix+"*").Result()
+			if len(keys) > 0 {
+				client.Del(ctx, keys...)
+			}
+		})
+		fn(t, NewRedisStore(client, prefix))
+	})
+}
+
+func TestStoreWindow(t *testing.T) {
+	stores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		const window = 300 * time.Millisecond
+
+		if count, reset, err := store.Peek(ctx, "k"); err != nil || count != 0 || reset != 0 {
+			t.Fatalf("Peek on a new key = %d, %v, %v; want 0, 0", count, reset, err)
+		}
+
+		for want := int64(1); want <= 3; want++ {
+			count, reset, err := store.Increment(ctx, "k", window)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != want {
+				t.Fatalf("hit %d counted as %d", want, count)
+			}
+			if reset <= 0 || reset > window {
+				t.Fatalf("reset %v outside (0, %v]", reset, window)
+			}
+		}
+
+		count, reset, err := store.Peek(ctx, "k")
+		if err != nil || count != 3 || reset <= 0 || reset > window {
+			t.Fatalf("Peek = %d, %v, %v; want 3 and a reset within the window", count, reset, err)
+		}
+		if count, _, _ := store.Peek(ctx, "k"); count != 3 {
+			t.Fatalf("Peek counted a hit, count now %d", count)
+		}
+
+		// the window closes and the next hit opens a new one
+		time.Sleep(window + 100*time.Millisecond)
+		if count, _, _ := store.Peek(ctx, "k"); count != 0 {
+			t.Fatalf("count %d after the window closed, want 0", count)
+		}
+		count, reset, err = store.Increment(ctx, "k", window)
+		if err != nil || count != 1 || reset <= window/2 {
+			t.Fatalf("first hit of the new window = %d, %v, %v; want 1 and a fresh reset", count, reset, err)
+		}
+	})
+}
+
+func TestStoreKeysApart(t *testing.T) {
+	stores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		store.Increment(ctx, "a", time.Minute)
+		store.Increment(ctx, "a", time.Minute)
+		if count, _, _ := store.Increment(ctx, "b", time.Minute); count != 1 {
+			t.Fatalf("key b counted %d, want 1", count)
+		}
+	})
+}
+
+func TestStoreConcurrentIncrements(t *testing.T) {
+	stores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		const workers, hits = 20, 25
+
+		var mu sync.Mutex
+		seen := map[int64]bool{}
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < hits; i++ {
+					count, _, err := store.Increment(ctx, "shared", time.Minute)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					if seen[count] {
+						t.Errorf("count %d handed out twice", count)
+					}
+					seen[count] = true
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if count, _, _ := store.Peek(ctx, "shared"); count != workers*hits {
+			t.Fatalf("count %d after %d hits", count, workers*hits)
+		}
+	})
+}
+
+func TestAllowAndExceeded(t *testing.T) {
+	stores(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		limit := Limit{Requests: 3, Window: time.Minute}
+
+		for i, want := range []Result{
+			{Allowed: true, Limit: 3, Remaining: 2},
+			{Allowed: true, Limit: 3, Remaining: 1},
+			{Allowed: true, Limit: 3, Remaining: 0},
+			{Allowed: false, Limit: 3, Remaining: 0},
+		} {
+			res, err := Allow(ctx, store, "k", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed != want.Allowed || res.Limit != want.Limit || res.Remaining != want.Remaining {
+				t.Fatalf("hit %d = %+v, want %+v", i+1, res, want)
+			}
+			if res.Reset <= 0 || res.Reset > limit.Window {
+				t.Fatalf("hit %d reset %v outside the window", i+1, res.Reset)
+			}
+		}
+
+		res, err := Exceeded(ctx, store, "k", limit)
+		if err != nil || res.Allowed {
+			t.Fatalf("Exceeded = %+v, %v; want not allowed", res, err)
+		}
+		res, err = Exceeded(ctx, store, "other", limit)
+		if err != nil || !res.Allowed || res.Remaining != 3 {
+			t.Fatalf("Exceeded on an unused key = %+v, %v", res, err)
+		}
+	})
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Limit
+		ok   bool
+	}{
+		{"30/1m", Limit{30, time.Minute}, true},
+		{" 5/1s ", Limit{5, time.Second}, true},
+		{"20/15m", Limit{20, 15 * time.Minute}, true},
+		{"30", Limit{}, false},
+		{"0/1m", Limit{}, false},
+		{"x/1m", Limit{}, false},
+		{"5/0s", Limit{}, false},
+		{"5/soon", Limit{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// incrementScript bumps the counter and opens the window on the first hit,
+// atomically so concurrent instances can't both think they started it.
+var incrementScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+var peekScript = redis.NewScript(`
+local count = redis.call("GET", KEYS[1])
+if not count then
+	return {0, 0}
+end
+return {tonumber(count), redis.call("PTTL", KEYS[1])}
+`)
+
+// RedisStore keeps the counters in Redis, shared by every instance using
+// the same server and prefix.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+func (s *RedisStore) Increment(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	res, err := incrementScript.Run(ctx, s.client, []string{s.prefix + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return res[0], time.Duration(res[1]) * time.Millisecond, nil
+}
+
+func (s *RedisStore) Peek(ctx context.Context, key string) (int64, time.Duration, error) {
+	res, err := peekScript.Run(ctx, s.client, []string{s.prefix + key}).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return res[0], time.Duration(max(res[1], 0)) * time.Millisecond, nil
+}
@@ -0,0 +1,162 @@
+// Package safepath resolves user supplied paths against a user's drive root
+// and guarantees the result never leaves that root, either lexically
+// ("../", absolute paths) or through symlinks placed inside the root.
+package safepath
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/iamgak/go-drive/pkg"
+)
+
+// maxNameLen is the longest file name most filesystems accept.
+const maxNameLen = 255
+
+type Root struct {
+	path string
+}
+
+// New returns a Root for dir. The directory must exist; its own symlinks are
+// evaluated once so later containment checks compare real paths.
+func New(dir string) (*Root, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Root{path: real}, nil
+}
+
+// Path returns the absolute, symlink free path of the root.
+func (r *Root) Path() string {
+	return r.path
+}
+
+// Resolve maps rel, a path relative to the root, to an absolute path inside
+// the root. Existing components are resolved through symlinks; the part that
+// does not exist yet (e.g. a folder about to be created) is appended as is.
+func (r *Root) Resolve(rel string) (string, error) {
+	if strings.ContainsRune(rel, 0) {
+		return "", pkg.ErrPathEscape
+	}
+
+	// Rooting rel before cleaning drops every leading "..", so the lexical
+	// result can never climb above the root.
+	rel = filepath.Clean(string(filepath.Separator) + filepath.FromSlash(rel))
+	target := filepath.Join(r.path, rel)
+
+	real, err := evalExisting(target)
+	if err != nil {
+		return "", err
+	}
+
+	if !Within(r.path, real) {
+		return "", pkg.ErrPathEscape
+	}
+
+	return real, nil
+}
+
+// Rel returns target relative to the root using forward slashes, as shown to
+// the user in listings and URLs.
+func (r *Root) Rel(target string) (string, error) {
+	if !Within(r.path, target) {
+		return "", pkg.ErrPathEscape
+	}
+
+	rel, err := filepath.Rel(r.path, target)
+	if err != nil {
+		return "", err
+	}
+
+	if rel == "." {
+		return "", nil
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// Within reports whether target is base or lies below it. Unlike a plain
+// strings.HasPrefix it respects separators, so "/drive/1" does not contain
+// "/drive/12".
+func Within(base, target string) bool {
+	base = filepath.Clean(base)
+	target = filepath.Clean(target)
+	if base == target {
+		return true
+	}
+
+	if !strings.HasSuffix(base, string(filepath.Separator)) {
+		base += string(filepath.Separator)
+	}
+
+	return strings.HasPrefix(target, base)
+}
+
+// SanitizeFilename validates a single file name coming from a client, such as
+// the multipart header.Filename. Directory parts are rejected rather than
+// stripped so "../../x" can't silently turn into "x".
+func SanitizeFilename(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." || len(name) > maxNameLen {
+		return "", pkg.ErrInvalidFilename
+	}
+
+	if strings.ContainsAny(name, `/\`) {
+		return "", pkg.ErrInvalidFilename
+	}
+
+	for _, r := range name {
+		if r == unicode.ReplacementChar || unicode.IsControl(r) {
+			return "", pkg.ErrInvalidFilename
+		}
+	}
+
+	return name, nil
+}
+
+// evalExisting resolves symlinks in the longest existing prefix of path and
+// re-attaches the missing tail.
+func evalExisting(path string) (string, error) {
+	var missing []string
+	current := path
+	for {
+		real, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			for i := len(missing) - 1; i >= 0; i-- {
+				real = filepath.Join(real, missing[i])
+			}
+			return real, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			// a dangling symlink shows up as ErrNotExist from EvalSymlinks, but
+			// anything else (loops, permissions) is refused outright
+			return "", err
+		}
+
+		// Never append a tail beneath a dangling symlink: the link could be
+		// created to point anywhere and the tail would follow it later.
+		if info, lerr := os.Lstat(current); lerr == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return "", pkg.ErrPathEscape
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", err
+		}
+
+		missing = append(missing, filepath.Base(current))
+		current = parent
+	}
+}
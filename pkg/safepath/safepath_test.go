@@ -0,0 +1,143 @@
+package safepath
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iamgak/go-drive/pkg"
+)
+
+// newTestRoot builds <tmp>/drive/1 as the root, with <tmp>/drive/10 next to
+// it and <tmp>/outside beyond, and inside the root:
+//
+//	docs/a.txt
+//	inner   -> docs              (stays inside)
+//	escape  -> <tmp>/outside     (leaves the root)
+//	sibling -> <tmp>/drive/10    (shares the root's prefix)
+//	dangling -> <tmp>/missing    (points nowhere yet)
+func newTestRoot(t testing.TB) (*Root, string) {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join(base, "drive", "1")
+	for _, dir := range []string{
+		filepath.Join(root, "docs"),
+		filepath.Join(base, "drive", "10"),
+		filepath.Join(base, "outside"),
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"inner":    filepath.Join(root, "docs"),
+		"escape":   filepath.Join(base, "outside"),
+		"sibling":  filepath.Join(base, "drive", "10"),
+		"dangling": filepath.Join(base, "missing"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symlinks not supported: ", err)
+		}
+	}
+
+	r, err := New(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, base
+}
+
+func TestResolve(t *testing.T) {
+	r, _ := newTestRoot(t)
+	root := r.Path()
+
+	tests := []struct {
+		rel  string
+		want string // relative to root; empty when the path must be refused
+	}{
+		{"", "."},
+		{"docs/a.txt", "docs/a.txt"},
+		{"docs/new/folder", "docs/new/folder"},
+		{"../../etc/passwd", "etc/passwd"},
+		{"/etc/passwd", "etc/passwd"},
+		{"docs/../../..", "."},
+		{`..\..\x`, `..\..\x`},
+		{"%2e%2e%2fx", "%2e%2e%2fx"},
+		{"inner/a.txt", "docs/a.txt"},
+		{"escape", ""},
+		{"escape/x", ""},
+		{"sibling", ""},
+		{"sibling/secret", ""},
+		{"dangling", ""},
+		{"dangling/x", ""},
+		{"docs/a\x00.txt", ""},
+	}
+	for _, tt := range tests {
+		got, err := r.Resolve(tt.rel)
+		if tt.want == "" {
+			if !errors.Is(err, pkg.ErrPathEscape) {
+				t.Errorf("Resolve(%q) = %q, %v; want ErrPathEscape", tt.rel, got, err)
+			}
+			continue
+		}
+
+		want := filepath.Join(root, filepath.FromSlash(tt.want))
+		if err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", tt.rel, got, err, want)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		base, target string
+		want         bool
+	}{
+		{"/drive/1", "/drive/1", true},
+		{"/drive/1", "/drive/1/", true},
+		{"/drive/1", "/drive/1/a/b", true},
+		{"/drive/1", "/drive/10", false},
+		{"/drive/1", "/drive/10/a", false},
+		{"/drive/1", "/drive/1/../10", false},
+		{"/drive/1", "/drive", false},
+		{"/drive/1/", "/drive/1x", false},
+	}
+	for _, tt := range tests {
+		if got := Within(tt.base, tt.target); got != tt.want {
+			t.Errorf("Within(%q, %q) = %t, want %t", tt.base, tt.target, got, tt.want)
+		}
+	}
+}
+
+// FuzzResolve checks that whatever a client sends, a path Resolve accepts is
+// inside the root. Seeds are in testdata/fuzz/FuzzResolve; run with
+//
+//	go test -fuzz FuzzResolve ./pkg/safepath
+func FuzzResolve(f *testing.F) {
+	r, base := newTestRoot(f)
+	outside := filepath.Join(base, "outside")
+
+	f.Fuzz(func(t *testing.T, rel string) {
+		got, err := r.Resolve(rel)
+		if err != nil {
+			return
+		}
+		if !Within(r.Path(), got) {
+			t.Fatalf("Resolve(%q) = %q, outside the root %q", rel, got, r.Path())
+		}
+		if Within(outside, got) || strings.ContainsRune(got, 0) {
+			t.Fatalf("Resolve(%q) = %q", rel, got)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 Appendix B, base32 encoded.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// The Appendix B SHA-1 test vectors. The RFC lists 8 digit codes; with 6
+// digits the code is their last six.
+func TestCodeAtRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := CodeAt(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.code[len(tt.code)-Digits:]; got != want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, want)
+		}
+	}
+
+	// secrets are accepted the way people paste them
+	got, err := CodeAt(" "+strings.ToLower(rfcSecret)+" ", Counter(time.Unix(59, 0)))
+	if err != nil || got != "287082" {
+		t.Errorf("lower case secret: %s, %v", got, err)
+	}
+	if _, err := CodeAt("not base32!", 1); err == nil {
+		t.Error("CodeAt accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	counter := Counter(now)
+	codeAt := func(step int64) string {
+		code, err := CodeAt(rfcSecret, counter+step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		lastCounter int64
+		want        int64
+		ok          bool
+	}{
+		{"current step", codeAt(0), 0, counter, true},
+		{"one step behind", codeAt(-1), 0, counter - 1, true},
+		{"one step ahead", codeAt(1), 0, counter + 1, true},
+		{"two steps behind", codeAt(-2), 0, 0, false},
+		{"two steps ahead", codeAt(2), 0, 0, false},
+		{"spaces", codeAt(0)[:3] + " " + codeAt(0)[3:] + " ", 0, counter, true},
+		{"too short", codeAt(0)[:5], 0, 0, false},
+		{"too long", codeAt(0) + "0", 0, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		// a code is spent once its counter is stored
+		{"replayed", codeAt(0), counter, 0, false},
+		{"older than the last used", codeAt(-1), counter, 0, false},
+		{"newer than the last used", codeAt(1), counter, counter + 1, true},
+	}
+	for _, tt := range tests {
+		got, ok := Validate(rfcSecret, tt.code, now, tt.lastCounter)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("%s: Validate = %d, %t, want %d, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	got := ProvisioningURI("Go Drive", "user@example.com", "ABC")
+	want := "otpauth://totp/Go%20Drive:user@example.com?algorithm=SHA1&digits=6&issuer=Go%20Drive&period=30&secret=ABC"
+	if got != want {
+		t.Errorf("ProvisioningURI = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/pkg/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultRateLimits are per user once signed in, per IP before. Each can be
+// changed with RATE_LIMIT_<NAME>, e.g. RATE_LIMIT_UPLOAD=10/1m.
+var defaultRateLimits = map[string]string{
+	"login":       "10/1m",
+	"drive_read":  "300/1m",
+	"drive_write": "60/1m",
+	"upload":      "30/1m",
+	"api":         "120/1m",
+	// failed logins per client IP, whatever the account
+	"login_failures": "20/15m",
+}
+
+type rateLimits struct {
+	store  ratelimit.Store
+	limits map[string]ratelimit.Limit
+}
+
+// loadRateLimits keeps the counters in memory, or in Redis at REDIS_URL
+// with RATE_LIMIT_STORE=redis so every instance shares them.
+func loadRateLimits() (*rateLimits, error) {
+	rl := &rateLimits{limits: make(map[string]ratelimit.Limit)}
+	for name, def := range defaultRateLimits {
+		limit, err := ratelimit.ParseLimit(def)
+		if v := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name)); v != "" {
+			limit, err = ratelimit.ParseLimit(v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rl.limits[name] = limit
+	}
+
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "memory":
+		rl.store = ratelimit.NewMemoryStore()
+	case "redis":
+		opts, err := redis.ParseURL(os.Getenv("REDIS_URL"))
+		if err != nil {
+			return nil, err
+		}
+		client := redis.NewClient(opts)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Ping(ctx).Err(); err != nil {
+			return nil, err
+		}
+		rl.store = ratelimit.NewRedisStore(client, "go-drive:ratelimit:")
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE %q, expected memory or redis", os.Getenv("RATE_LIMIT_STORE"))
+	}
+	return rl, nil
+}
+
+// rateLimit applies the named limit. Put after LoginMiddleware it counts
+// per user, otherwise per client IP. If the store is down requests are let
+// through rather than locking everyone out.
+func (app *Application) rateLimit(name string) gin.HandlerFunc {
+	limit, ok := app.RateLimits.limits[name]
+	if !ok {
+		panic("rate limit " + name + " is not configured")
+	}
+	policy := strconv.Itoa(limit.Requests) + ";w=" + strconv.Itoa(int(limit.Window.Seconds()))
+
+	return func(c *gin.Context) {
+		key := name + ":ip:" + c.ClientIP()
+		if v, ok := c.Get(authUserKey); ok {
+			key = name + ":user:" + strconv.FormatUint(uint64(v.(*authUser).ID), 10)
+		}
+
+		res, err := ratelimit.Allow(c.Request.Context(), app.RateLimits.store, key, limit)
+		if err != nil {
+			app.log(c).Error("Rate limiter unavailable, letting request through: ", err)
+			c.Next()
+			return
+		}
+
+		reset := strconv.Itoa(int(math.Ceil(res.Reset.Seconds())))
+		c.Header("RateLimit-Policy", policy)
+		c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Header("RateLimit-Reset", reset)
+
+		if !res.Allowed {
+			app.Metrics.rateLimited.WithLabelValues(c.FullPath()).Inc()
+			app.log(c).Warn("Rate limit ", name, " exceeded")
+			c.Header("Retry-After", reset)
+			app.ErrorJSONResponse(c.Writer, http.StatusTooManyRequests, "Too many requests, try again in "+reset+" seconds")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// trustedProxies reads the comma separated TRUSTED_PROXIES, IPs or CIDRs.
+func trustedProxies() []string {
+	var proxies []string
+	for _, p := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return proxies
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/pkg/ratelimit"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	app := &Application{
+		Logger:  logger,
+		Metrics: newMetrics(t.TempDir()),
+		RateLimits: &rateLimits{
+			store:  ratelimit.NewMemoryStore(),
+			limits: map[string]ratelimit.Limit{"api": {Requests: 2, Window: time.Minute}},
+		},
+	}
+
+	reached := 0
+	r := gin.New()
+	r.GET("/x", app.rateLimit("api"), func(c *gin.Context) {
+		reached++
+		c.Status(http.StatusOK)
+	})
+
+	get := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/x", nil)
+		req.RemoteAddr = ip + ":40000"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, want := range []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusTooManyRequests, "0"},
+	} {
+		w := get("10.0.0.1")
+		if w.Code != want.status {
+			t.Fatalf("request %d: status %d, want %d", i+1, w.Code, want.status)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: RateLimit-Limit %q", i+1, got)
+		}
+		if got := w.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+			t.Errorf("request %d: RateLimit-Policy %q", i+1, got)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != want.remaining {
+			t.Errorf("request %d: RateLimit-Remaining %q, want %q", i+1, got, want.remaining)
+		}
+		reset, err := strconv.Atoi(w.Header().Get("RateLimit-Reset"))
+		if err != nil || reset < 1 || reset > 60 {
+			t.Errorf("request %d: RateLimit-Reset %q", i+1, w.Header().Get("RateLimit-Reset"))
+		}
+
+		retryAfter := w.Header().Get("Retry-After")
+		if want.status == http.StatusTooManyRequests {
+			if retryAfter != w.Header().Get("RateLimit-Reset") {
+				t.Errorf("Retry-After %q, want the reset %q", retryAfter, w.Header().Get("RateLimit-Reset"))
+			}
+		} else if retryAfter != "" {
+			t.Errorf("request %d: Retry-After %q on an allowed request", i+1, retryAfter)
+		}
+	}
+
+	if reached != 2 {
+		t.Fatalf("handler ran %d times, want 2: the rejected request wasn't aborted", reached)
+	}
+
+	// another client has a counter of its own
+	if w := get("10.0.0.2"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("other IP: status %d, remaining %q", w.Code, w.Header().Get("RateLimit-Remaining"))
+	}
+}
@@ -4,13 +4,29 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/models"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func (app *Application) InitRouter() *gin.Engine {
 	r := gin.New()
-	r.Use(gin.Logger())
+	// c.ClientIP() keys the per IP limits, so X-Forwarded-For is only
+	// believed from the proxies listed in TRUSTED_PROXIES
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		app.Logger.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+	// first, so every span of the request hangs off its server span
+	r.Use(otelgin.Middleware("go-drive"))
+	r.Use(app.RequestIDMiddleware())
+	r.Use(app.AccessLog())
+	// outside Recovery so a panic is still counted as the 500 it becomes
+	r.Use(app.Metrics.middleware())
 	r.Use(gin.Recovery())
-	r.Use(MaintenanceMiddleware())
+	if app.TLSEnabled && app.HSTSMaxAge > 0 {
+		r.Use(hsts(app.HSTSMaxAge))
+	}
+	r.Use(app.securityHeaders())
+	r.Use(app.MaintenanceMiddleware())
 	r.Use(app.TimeoutMiddleware(5 * time.Second))
 	// read API
 
@@ -18,26 +34,103 @@ func (app *Application) InitRouter() *gin.Engine {
 
 	authorise := r.Group("/drive")
 
-	authorise.Use(app.LoginMiddleware(), secureHeaders(), app.rateLimiter())
+	authorise.Use(app.LoginMiddleware())
 	{
 		//listing of all the users files and folders
-		authorise.GET("/*path", app.DriveListing)
+		authorise.GET("/*path", app.rateLimit("drive_read"), app.requireScope(models.ScopeDriveRead), app.DriveListing)
 		// write API
-		authorise.POST("/create", app.CreateFolder)         //Create new folder
-		authorise.POST("/upload/", app.UploadFile)          //Create new file
-		authorise.PUT("/rename", app.RenameFolder)          // rename file or folder
-		authorise.DELETE("/delete", app.DeleteFileOrFolder) // deleter file or folder
+		write := app.requireScope(models.ScopeDriveWrite)
+		writeLimit := app.rateLimit("drive_write")
+		authorise.POST("/create", writeLimit, write, app.CreateFolder)             //Create new folder
+		authorise.POST("/upload/", app.rateLimit("upload"), write, app.UploadFile) //Create new file
+		authorise.PUT("/rename", writeLimit, write, app.RenameFolder)              // rename file or folder
+		authorise.DELETE("/delete", writeLimit, write, app.DeleteFileOrFolder)     // deleter file or folder
+	}
+
+	account := r.Group("/account")
+	account.Use(app.LoginMiddleware(), app.requireSession())
+	{
+		account.GET("/sessions", app.ShowSessionsPage)
+		account.GET("/2fa", app.ShowTwoFactorPage)
+		account.GET("/tokens", app.ShowAccessTokensPage)
+	}
+
+	api := r.Group("/api")
+	api.Use(app.LoginMiddleware(), app.requireSession(), app.rateLimit("api"))
+	{
+		// logged in devices
+		api.GET("/sessions", app.ListSessions)
+		api.DELETE("/sessions", app.RevokeOtherSessions)
+		api.DELETE("/sessions/:id", app.RevokeSession)
+
+		// two factor enrollment
+		api.POST("/2fa/setup", app.SetupTwoFactor)
+		api.GET("/2fa/qr", app.TwoFactorQRCode)
+		api.POST("/2fa/confirm", app.ConfirmTwoFactor)
+		api.POST("/2fa/disable", app.DisableTwoFactor)
+
+		// own activity history
+		api.GET("/activity", app.ListActivity)
+		api.GET("/activity/export", app.ExportActivity)
+
+		// personal access tokens for scripts
+		api.GET("/tokens", app.ListAccessTokens)
+		api.POST("/tokens", app.CreateAccessToken)
+		api.DELETE("/tokens/:id", app.RevokeAccessToken)
+	}
+
+	admin := r.Group("/api/admin")
+	admin.Use(app.LoginMiddleware(), app.requireSession(), app.rateLimit("api"), app.requireRole(models.RoleAdmin))
+	{
+		admin.GET("/users", app.ListUsers)
+		admin.GET("/users/:id", app.GetUser)
+		admin.PUT("/users/:id/active", app.SetUserActive)
+		admin.PUT("/users/:id/role", app.SetUserRole)
+		admin.PUT("/users/:id/quota", app.SetUserQuota)
+		admin.POST("/users/:id/password-reset", app.ForcePasswordReset)
+		admin.DELETE("/users/:id/sessions", app.RevokeUserSessions)
+		admin.DELETE("/users/:id", app.DeleteUser)
+	}
+
+	// auditors can read everyone's activity but change nothing
+	audit := r.Group("/api/admin/activity")
+	audit.Use(app.LoginMiddleware(), app.requireSession(), app.rateLimit("api"), app.requireRole(models.RoleAdmin, models.RoleAuditor))
+	{
+		audit.GET("", app.AdminListActivity)
+		audit.GET("/export", app.AdminExportActivity)
 	}
 
 	//html pages
 	r.GET("/login", app.ShowLoginPage)
 	r.GET("/register", app.ShowRegisterPage)
+	r.GET("/forgot-password", app.ShowForgotPasswordPage)
+	r.GET("/reset-password/:token", app.ShowResetPasswordPage)
+
+	// req handle, limited per client IP
+	login := app.rateLimit("login")
+	r.POST("/login", login, app.UserLogin)
+	r.POST("/login/2fa", login, app.TwoFactorLogin)
+	r.POST("/register", login, app.UserRegister)
+	r.POST("/refresh", app.RefreshSession)
+	r.POST("/logout", app.UserLogout)
+	r.POST("/forgot-password", login, app.ForgotPassword)
+	r.POST("/reset-password", login, app.ResetPassword)
+
+	// single sign-on
+	r.GET("/auth/oidc/:provider/login", app.OIDCLogin)
+	r.GET("/auth/oidc/:provider/callback", app.OIDCCallback)
+
+	app.metricsRoute(r)
 
-	// req handle
-	r.POST("/login", app.UserLogin)
-	r.POST("/register", app.UserRegister)
+	// probes for the orchestrator, outside the rate limiter and maintenance
+	r.GET("/healthz", app.Healthz)
+	r.GET("/readyz", app.Readyz)
+	r.GET("/version", app.Version)
 
 	//account activate after registration
 	r.GET("/activation_token/:token", app.UserActivateAccount)
+	r.POST("/activation/resend", login, app.ResendActivation)
+	// link from the lockout email
+	r.GET("/unlock/:token", login, app.UnlockAccount)
 	return r
 }
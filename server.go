@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type serveOptions struct {
+	DrainTimeout time.Duration
+	// Certs switches the server to TLS when set.
+	Certs *certReloader
+	// RedirectAddr, when set together with Certs, runs a plain HTTP listener
+	// that redirects everything to HTTPS.
+	RedirectAddr string
+	// MetricsAddr, when set, serves /metrics on its own listener instead of
+	// the main one, e.g. 127.0.0.1:9100 to keep it off the public interface.
+	MetricsAddr string
+}
+
+// serve runs srv until it fails or the process receives SIGINT/SIGTERM, in
+// which case in-flight requests (uploads included) get DrainTimeout to finish
+// before the remaining connections are closed.
+func (app *Application) serve(srv *http.Server, opts serveOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if app.Audit.Key != nil {
+		go app.runAuditCheckpoints(ctx)
+	}
+
+	servers := []*http.Server{srv}
+	errCh := make(chan error, 3)
+
+	if opts.Certs != nil {
+		srv.TLSConfig = opts.Certs.tlsConfig()
+		go app.watchSIGHUP(ctx, opts.Certs)
+		go func() {
+			errCh <- srv.ListenAndServeTLS("", "")
+		}()
+
+		if opts.RedirectAddr != "" {
+			redirect := &http.Server{
+				Addr:              opts.RedirectAddr,
+				Handler:           redirectToHTTPS(srv.Addr),
+				ReadHeaderTimeout: 5 * time.Second,
+			}
+			servers = append(servers, redirect)
+			app.Logger.Info("redirecting http to https from ", opts.RedirectAddr)
+			go func() {
+				errCh <- redirect.ListenAndServe()
+			}()
+		}
+	} else {
+		go func() {
+			errCh <- srv.ListenAndServe()
+		}()
+	}
+
+	if opts.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", app.Metrics.handler(os.Getenv("METRICS_TOKEN")))
+		metricsSrv := &http.Server{
+			Addr:              opts.MetricsAddr,
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		servers = append(servers, metricsSrv)
+		app.Logger.Info("serving metrics on ", opts.MetricsAddr)
+		go func() {
+			errCh <- metricsSrv.ListenAndServe()
+		}()
+	}
+
+	var serveErr error
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			serveErr = err
+		}
+	case <-ctx.Done():
+		app.Logger.Infof("Shutdown signal received, draining requests for up to %s", opts.DrainTimeout)
+	}
+
+	// a second signal kills the process straight away
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.DrainTimeout)
+	defer cancel()
+
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			s.Close()
+			if serveErr == nil {
+				serveErr = err
+			}
+		}
+	}
+
+	// emails queued by the last requests still go out
+	bgDone := make(chan struct{})
+	go func() {
+		app.bg.Wait()
+		close(bgDone)
+	}()
+
+	select {
+	case <-bgDone:
+	case <-shutdownCtx.Done():
+		app.Logger.Warn("Background tasks still running at shutdown")
+	}
+
+	app.Logger.Info("Server stopped")
+	return serveErr
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/models"
+	"github.com/iamgak/go-drive/pkg"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+func (app *Application) ShowSessionsPage(c *gin.Context) {
+	user := app.currentUser(c)
+	sessions, err := app.Model.UsersORM.ActiveSessions(c.Request.Context(), user.ID, user.SessionID)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	otelgin.HTML(c, http.StatusOK, "sessions.html", gin.H{
+		"title":     "Active Sessions",
+		"Email":     user.Email,
+		"Sessions":  sessions,
+		"CSRFToken": app.pageCSRFToken(c),
+		"CSPNonce":  cspNonce(c),
+	})
+}
+
+func (app *Application) ListSessions(c *gin.Context) {
+	user := app.currentUser(c)
+	sessions, err := app.Model.UsersORM.ActiveSessions(c.Request.Context(), user.ID, user.SessionID)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, sessions)
+}
+
+// RevokeSession ends one session by id. Revoking the current one works like
+// logout and clears the cookies as well.
+func (app *Application) RevokeSession(c *gin.Context) {
+	user := app.currentUser(c)
+	familyID := c.Param("id")
+
+	err := app.Model.UsersORM.RevokeUserSession(c.Request.Context(), user.ID, familyID)
+	if err == pkg.ErrNoRecord {
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "Session not found")
+		return
+	}
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.recordEvent(c, models.Event{Action: models.ActionSessionRevoked, UserID: user.ID, Details: map[string]any{"current": familyID == user.SessionID}})
+
+	if familyID == user.SessionID {
+		app.clearAuthCookies(c.Writer)
+	}
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Session Revoked")
+}
+
+// RevokeOtherSessions signs the user out of every device but this one.
+func (app *Application) RevokeOtherSessions(c *gin.Context) {
+	user := app.currentUser(c)
+	revoked, err := app.Model.UsersORM.RevokeOtherSessions(c.Request.Context(), user.ID, user.SessionID)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.recordEvent(c, models.Event{Action: models.ActionOtherSessionsRevoked, UserID: user.ID, Details: map[string]any{"revoked": revoked}})
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{"revoked": revoked})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadTempPrefix marks files UploadFile is still writing. They are renamed
+// into place once complete, so a crash or kill mid-write never leaves a
+// truncated file under the real name.
+const uploadTempPrefix = ".upload-"
+
+// writeFileAtomic streams r into a temp file next to dst and renames it over
+// dst only after the data has been flushed to disk.
+func writeFileAtomic(dst string, r io.Reader) (int64, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), uploadTempPrefix+"*")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return n, err
+	}
+
+	return n, nil
+}
+
+// isTempUpload reports whether name belongs to an upload still in progress.
+func isTempUpload(name string) bool {
+	return strings.HasPrefix(name, uploadTempPrefix)
+}
+
+// cleanupOrphanedUploads removes temp files left behind by uploads that were
+// interrupted before they could be renamed into place.
+func (app *Application) cleanupOrphanedUploads() error {
+	removed := 0
+	err := filepath.WalkDir(app.DriveRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if d.Type().IsRegular() && isTempUpload(d.Name()) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+
+	if removed > 0 {
+		app.Logger.Infof("Removed %d orphaned upload temp files", removed)
+	}
+	return err
+}
+
+// dirUsage sums the size of the regular files under dir; a missing dir is
+// simply empty.
+func dirUsage(dir string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// tracedDirUsage is dirUsage in a span of the request behind ctx.
+func tracedDirUsage(ctx context.Context, dir string) (total int64, err error) {
+	err = traceFS(ctx, "usage", dir, func() (err error) {
+		total, err = dirUsage(dir)
+		return err
+	})
+	return total, err
+}
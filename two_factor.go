@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/iamgak/go-drive/pkg"
+	qrcode "github.com/skip2/go-qrcode"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// totpIssuer is the account name prefix shown in authenticator apps.
+func totpIssuer() string {
+	return pkg.EnvString("TOTP_ISSUER", "Go Drive")
+}
+
+func (app *Application) ShowTwoFactorPage(c *gin.Context) {
+	user := app.currentUser(c)
+	enabled, err := app.Model.UsersORM.TwoFactorEnabled(c.Request.Context(), user.ID)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	otelgin.HTML(c, http.StatusOK, "two_factor.html", gin.H{
+		"title":     "Two-Factor Authentication",
+		"Email":     user.Email,
+		"Enabled":   enabled,
+		"CSRFToken": app.pageCSRFToken(c),
+		"CSPNonce":  cspNonce(c),
+	})
+}
+
+// TwoFactorLogin is the second login step for accounts with 2FA on.
+func (app *Application) TwoFactorLogin(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing mfa_token or code")
+		return
+	}
+
+	tokens, err := app.Model.UsersORM.CompleteTwoFactorLogin(c.Request.Context(), req.MFAToken, req.Code)
+	if err != nil {
+		app.log(c).Error(err.Error())
+		switch err {
+		case pkg.ErrInvalidToken:
+			app.ErrorJSONResponse(c.Writer, http.StatusUnauthorized, "Login expired, please start again")
+		case pkg.ErrInvalidTwoFactorCode, pkg.ErrAccountInActive:
+			app.Metrics.login("2fa", false)
+			app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		case pkg.ErrTooManyRequests:
+			app.ErrorJSONResponse(c.Writer, http.StatusTooManyRequests, err.Error())
+		default:
+			app.ServerError(c, err)
+		}
+		return
+	}
+
+	app.Metrics.login("2fa", true)
+	app.setAuthCookies(c.Writer, tokens)
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Login Successfull")
+}
+
+func (app *Application) SetupTwoFactor(c *gin.Context) {
+	setup, err := app.Model.UsersORM.SetupTwoFactor(c.Request.Context(), app.currentUser(c).ID, totpIssuer())
+	if err == pkg.ErrTwoFactorEnabled {
+		app.ErrorJSONResponse(c.Writer, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, setup)
+}
+
+// TwoFactorQRCode renders the pending provisioning URI as a PNG to scan.
+func (app *Application) TwoFactorQRCode(c *gin.Context) {
+	uri, err := app.Model.UsersORM.PendingTwoFactorURI(c.Request.Context(), app.currentUser(c).ID, totpIssuer())
+	switch err {
+	case nil:
+	case pkg.ErrNoRecord, pkg.ErrTwoFactorEnabled:
+		app.ErrorJSONResponse(c.Writer, http.StatusNotFound, "No pending two factor setup")
+		return
+	default:
+		app.ServerError(c, err)
+		return
+	}
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		app.ServerError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+func (app *Application) ConfirmTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing code")
+		return
+	}
+
+	codes, err := app.Model.UsersORM.ConfirmTwoFactor(c.Request.Context(), app.currentUser(c).ID, req.Code)
+	switch err {
+	case nil:
+	case pkg.ErrInvalidTwoFactorCode, pkg.ErrTwoFactorNotEnabled:
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		return
+	case pkg.ErrTwoFactorEnabled:
+		app.ErrorJSONResponse(c.Writer, http.StatusConflict, err.Error())
+		return
+	default:
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (app *Application) DisableTwoFactor(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Password == "" {
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, "Missing password")
+		return
+	}
+
+	err := app.Model.UsersORM.DisableTwoFactor(c.Request.Context(), app.currentUser(c).ID, req.Password)
+	switch err {
+	case nil:
+	case pkg.ErrIncorrectPassword, pkg.ErrTwoFactorNotEnabled:
+		app.ErrorJSONResponse(c.Writer, http.StatusBadRequest, err.Error())
+		return
+	default:
+		app.ServerError(c, err)
+		return
+	}
+
+	app.sendJSONResponse(c.Writer, http.StatusOK, "Two factor authentication disabled")
+}